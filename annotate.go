@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/c-bata/goptuna"
+)
+
+// runOutputAttrs are the user attrs parsed from the output of ffm-train,
+// which -annotate-trials can't backfill without re-running the trial.
+var runOutputAttrs = []string{"best_iteration", "va_loss", "stdout", "stderr"}
+
+// annotateTrials backfills the user attrs which the finished trials of the
+// study lack but which can be derived from their params and values, like the
+// trials stored before an attr was added. The attrs are derived by the flags,
+// so those of the sweep must be given, e.g. -normalize and -train-seed-flag.
+// The attrs of a trial are never overwritten, and the ones which can't be
+// derived are logged.
+func annotateTrials(cfg *Config) error {
+	study, db, err := loadExistingStudy(cfg.DSN, cfg.StudyName)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	r, err := newOnceRunner(cfg)
+	if err != nil {
+		return err
+	}
+	trials, err := study.GetTrials()
+	if err != nil {
+		return err
+	}
+	sort.Slice(trials, func(i, j int) bool { return trials[i].Number < trials[j].Number })
+
+	var annotated, added, skipped int
+	for _, t := range trials {
+		if t.State == goptuna.TrialStateRunning {
+			continue
+		}
+		attrs, skips := r.annotations(t)
+		keys := make([]string, 0, len(attrs))
+		for key := range attrs {
+			if _, ok := t.UserAttrs[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err = study.Storage.SetTrialUserAttr(t.ID, key, attrs[key]); err != nil {
+				return fmt.Errorf("failed to store the user attr %s of trial %d: %s", key, t.Number, err)
+			}
+			log.Printf("trial %d: added %s=%q", t.Number, key, attrs[key])
+		}
+		for _, s := range skips {
+			log.Printf("trial %d: skipped %s", t.Number, s)
+		}
+		if len(keys) > 0 {
+			annotated++
+		}
+		added += len(keys)
+		skipped += len(skips)
+	}
+	log.Printf("added %d attrs to %d of %d trials, skipped %d", added, annotated, len(trials), skipped)
+	return nil
+}
+
+// annotations returns the user attrs which the sweep would have stored for the
+// trial and which can be derived, and the reasons of the missing ones which
+// can't.
+func (r *runner) annotations(t goptuna.FrozenTrial) (map[string]string, []string) {
+	cfg := r.cfg
+	attrs := make(map[string]string)
+	var skips []string
+	if _, ok := t.UserAttrs["pruned_by"]; ok {
+		// pruned before ffm-train ran, so there's nothing of a run.
+		return attrs, nil
+	}
+	params, err := trialParams(t)
+	if err != nil {
+		return attrs, []string{err.Error()}
+	}
+	lmd, eta, latent, err := effectiveParams(params)
+	if err != nil {
+		return attrs, []string{fmt.Sprintf("the params: %s", err)}
+	}
+
+	if cfg.RandomSplit > 0 {
+		attrs["split_seed"] = strconv.FormatInt(splitSeed(cfg.DataSeed, t.Number), 10)
+	}
+	if cfg.Trainer == nil {
+		attrs["lambda_arg"] = formatFloatArg(lmd, cfg.ParamPrecision)
+		attrs["eta_arg"] = formatFloatArg(eta, cfg.ParamPrecision)
+		if command, err := r.trialCommand(t, params); err != nil {
+			if _, ok := t.UserAttrs["command"]; !ok {
+				skips = append(skips, fmt.Sprintf("command: %s", err))
+			}
+		} else {
+			attrs["command"] = command
+		}
+		if t.State == goptuna.TrialStateComplete {
+			for _, key := range runOutputAttrs {
+				if _, ok := t.UserAttrs[key]; !ok {
+					skips = append(skips, fmt.Sprintf("%s: it's the output of ffm-train", key))
+				}
+			}
+		}
+	}
+
+	if t.State != goptuna.TrialStateComplete || t.UserAttrs[divergedAttrKey] == "true" {
+		return attrs, skips
+	}
+	if cfg.Normalize {
+		raw, normalized, err := r.normalizedValues(t)
+		if err != nil {
+			skips = append(skips, err.Error())
+		} else {
+			attrs["raw_value"] = fmt.Sprintf("%f", raw)
+			attrs["normalized_value"] = fmt.Sprintf("%f", normalized)
+		}
+	}
+	if cfg.LatentPenalty != 0 {
+		attrs["latent_penalty"] = fmt.Sprintf("%f", cfg.LatentPenalty*float64(latent))
+	}
+	return attrs, skips
+}
+
+// trialCommand returns the command of ffm-train of the trial as the default
+// Trainer builds it.
+func (r *runner) trialCommand(t goptuna.FrozenTrial, params map[string]interface{}) (string, error) {
+	cfg := r.cfg
+	if cfg.SelectFields {
+		return "", errors.New("the data of -select-fields isn't kept")
+	}
+	lmd, eta, latent, err := effectiveParams(params)
+	if err != nil {
+		return "", err
+	}
+	var trainSeed int
+	if cfg.TrainSeedFlag != "" {
+		if trainSeed, err = intParam(t.Params, "train_seed"); err != nil {
+			return "", err
+		}
+	}
+	schedule, err := scheduleArgs(cfg, params)
+	if err != nil {
+		return "", err
+	}
+	data := dataPaths{train: cfg.TrainPath, valid: cfg.ValidPath}
+	if cfg.RandomSplit > 0 {
+		data = splitPaths(cfg.TrainPath, t.Number)
+	}
+	commands := make([]string, cfg.Repeats)
+	for i := range commands {
+		name := strconv.Itoa(t.Number)
+		if cfg.Repeats > 1 {
+			name = fmt.Sprintf("%d-%d", t.Number, i)
+		}
+		run := r.trainRun(t.Number, name, lmd, eta, latent, trainSeed+i, schedule, data)
+		commands[i] = shellJoin(append([]string{cfg.TrainBin}, run.args...))
+	}
+	return strings.Join(commands, "\n"), nil
+}
+
+// normalizedValues returns the raw and the normalized values of -normalize of
+// the trial from whichever of them it has, or from its value if neither
+// -latent-penalty nor Config.ValueTransform changed it.
+func (r *runner) normalizedValues(t goptuna.FrozenTrial) (float64, float64, error) {
+	if s, ok := t.UserAttrs["raw_value"]; ok {
+		raw, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("normalized_value: invalid raw_value %q", s)
+		}
+		return raw, (r.baseline - raw) / r.baseline, nil
+	}
+	normalized := t.Value
+	if s, ok := t.UserAttrs["normalized_value"]; ok {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("raw_value: invalid normalized_value %q", s)
+		}
+		normalized = v
+	} else if r.cfg.LatentPenalty != 0 || r.cfg.ValueTransform != nil {
+		return 0, 0, errors.New("raw_value, normalized_value: the value isn't the normalized one")
+	}
+	return r.baseline - normalized*r.baseline, normalized, nil
+}
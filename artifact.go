@@ -0,0 +1,126 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/c-bata/goptuna"
+)
+
+// The files in the tarball of -artifact, besides the model.
+const (
+	artifactManifest = "manifest.json"
+	artifactParams   = "params.json"
+	artifactScript   = "train.sh"
+)
+
+// artifactManifestFile describes the tarball of -artifact.
+type artifactManifestFile struct {
+	Study   string    `json:"study"`
+	Trial   int       `json:"trial"`
+	Value   float64   `json:"value"`
+	Metric  string    `json:"metric"`
+	RunID   string    `json:"run_id,omitempty"`
+	Created time.Time `json:"created"`
+	// Model is the name of the model file in the tarball.
+	Model     string     `json:"model"`
+	ModelInfo *ModelInfo `json:"model_info,omitempty"`
+}
+
+// writeArtifact bundles the model retrained from the trial, its params and
+// the script to retrain it into a tar.gz at path, with a manifest naming the
+// study and the value. The tarball is renamed into place once it's complete.
+func writeArtifact(path string, cfg *Config, trial goptuna.FrozenTrial, modelPath string, model *ModelInfo) error {
+	params, err := trialParams(trial)
+	if err != nil {
+		return err
+	}
+	paramsJSON, err := json.MarshalIndent(params, "", "  ")
+	if err != nil {
+		return err
+	}
+	script, err := trainScript(cfg, trial)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	manifest, err := json.MarshalIndent(artifactManifestFile{
+		Study:     cfg.StudyName,
+		Trial:     trial.Number,
+		Value:     trial.Value,
+		Metric:    cfg.Metric,
+		RunID:     trial.SystemAttrs[runIDAttrKey],
+		Created:   now.UTC(),
+		Model:     filepath.Base(modelPath),
+		ModelInfo: model,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	gz := gzip.NewWriter(tmp)
+	tw := tar.NewWriter(gz)
+	for _, f := range []struct {
+		name string
+		mode int64
+		data []byte
+	}{
+		{artifactManifest, 0644, manifest},
+		{artifactParams, 0644, paramsJSON},
+		{artifactScript, 0755, script},
+	} {
+		hdr := &tar.Header{Name: f.name, Mode: f.mode, Size: int64(len(f.data)), ModTime: now}
+		if err = tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err = tw.Write(f.data); err != nil {
+			return err
+		}
+	}
+	if err = addFileToTar(tw, modelPath, now); err != nil {
+		return err
+	}
+	if err = tw.Close(); err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// addFileToTar adds the file by its base name.
+func addFileToTar(tw *tar.Writer, path string, modTime time.Time) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{Name: filepath.Base(path), Mode: 0644, Size: fi.Size(), ModTime: modTime}
+	if err = tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// artifactStore stores the files of the runs besides ./data/optuna, which
+// ffm-train writes to, so that they outlive ephemeral workers.
+type artifactStore interface {
+	// Put stores size bytes of r as name.
+	Put(ctx context.Context, name string, r io.Reader, size int64) error
+}
+
+// localStore is the default store, which leaves the files in ./data/optuna.
+type localStore struct{}
+
+func (localStore) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	return nil
+}
+
+// newArtifactStore returns the store of -artifact-store: empty for the local
+// files only, or s3://bucket/prefix for an S3-compatible bucket.
+func newArtifactStore(spec string) (artifactStore, error) {
+	if spec == "" {
+		return localStore{}, nil
+	}
+	if strings.HasPrefix(spec, "s3://") {
+		bucketPrefix := strings.TrimPrefix(spec, "s3://")
+		bucket, prefix := bucketPrefix, ""
+		if i := strings.IndexByte(bucketPrefix, '/'); i >= 0 {
+			bucket, prefix = bucketPrefix[:i], strings.Trim(bucketPrefix[i+1:], "/")
+		}
+		if bucket == "" {
+			return nil, fmt.Errorf("-artifact-store %s has no bucket", spec)
+		}
+		return newS3Store(bucket, prefix)
+	}
+	return nil, fmt.Errorf("-artifact-store must be s3://bucket/prefix, got %q", spec)
+}
+
+// storeRun puts the files of the run which are on disk and its output to the
+// store under the study, e.g. "study/ffm-meta-3.json". A failure is logged,
+// and the trial goes on.
+func (r *runner) storeRun(run trainRun, stdout, stderr []byte) {
+	if _, ok := r.store.(localStore); ok || r.store == nil {
+		return
+	}
+	// stored after a timeout or a cancel too.
+	ctx := context.Background()
+	prefix := r.cfg.StudyName + "/"
+	for _, path := range []string{run.jsonMetaPath, run.modelPath, run.predPath} {
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err == nil {
+			var fi os.FileInfo
+			if fi, err = f.Stat(); err == nil {
+				err = r.store.Put(ctx, prefix+filepath.Base(path), f, fi.Size())
+			}
+			f.Close()
+		}
+		if err != nil {
+			logStoreError(path, err)
+		}
+	}
+	for _, out := range []struct {
+		name string
+		b    []byte
+	}{
+		{fmt.Sprintf("ffm-train-%s.stdout", run.name), stdout},
+		{fmt.Sprintf("ffm-train-%s.stderr", run.name), stderr},
+	} {
+		if err := r.store.Put(ctx, prefix+out.name, bytes.NewReader(out.b), int64(len(out.b))); err != nil {
+			logStoreError(out.name, err)
+		}
+	}
+}
+
+func logStoreError(name string, err error) {
+	log.Printf("WARNING: failed to store %s to -artifact-store: %s", name, err)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/c-bata/goptuna"
+)
+
+// studySnapshot is the content of the -backup file. Unlike -export, it keeps
+// every attr, so that the study can be recovered if the storage is corrupted.
+type studySnapshot struct {
+	Study       string            `json:"study"`
+	Direction   string            `json:"direction"`
+	UserAttrs   map[string]string `json:"user_attrs"`
+	SystemAttrs map[string]string `json:"system_attrs"`
+	SnapshotAt  time.Time         `json:"snapshot_at"`
+	Trials      []exportedTrial   `json:"trials"`
+}
+
+// writeSnapshot writes all trials of the study to path. It writes a temporary
+// file next to path and renames it, so the previous snapshot is kept intact
+// until the new one is complete.
+func writeSnapshot(path, studyName string, study *goptuna.Study) error {
+	trials, err := study.GetTrials()
+	if err != nil {
+		return err
+	}
+	sort.Slice(trials, func(i, j int) bool {
+		return trials[i].Number < trials[j].Number
+	})
+	userAttrs, err := study.GetUserAttrs()
+	if err != nil {
+		return err
+	}
+	systemAttrs, err := study.GetSystemAttrs()
+	if err != nil {
+		return err
+	}
+	snapshot := studySnapshot{
+		Study:       studyName,
+		Direction:   string(study.Direction()),
+		UserAttrs:   userAttrs,
+		SystemAttrs: systemAttrs,
+		SnapshotAt:  time.Now(),
+		Trials:      make([]exportedTrial, len(trials)),
+	}
+	for i := range trials {
+		snapshot.Trials[i] = newExportedTrial(trials[i], nil)
+	}
+	b, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	// TempFile creates the file only readable by the owner.
+	if err = tmp.Chmod(0644); err == nil {
+		_, err = tmp.Write(b)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// backuper snapshots the study to the -backup file periodically and once more
+// when it's closed.
+type backuper struct {
+	path      string
+	studyName string
+	study     *goptuna.Study
+	interval  time.Duration
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func newBackuper(cfg *Config, study *goptuna.Study) *backuper {
+	b := &backuper{
+		path:      cfg.Backup,
+		studyName: cfg.StudyName,
+		study:     study,
+		interval:  cfg.BackupInterval,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go b.loop()
+	return b
+}
+
+func (b *backuper) loop() {
+	defer close(b.done)
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := writeSnapshot(b.path, b.studyName, b.study); err != nil {
+				log.Print("failed to back up the study:", err)
+			}
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// Close stops the periodic snapshots and writes the final one.
+func (b *backuper) Close() error {
+	close(b.stop)
+	<-b.done
+	return writeSnapshot(b.path, b.studyName, b.study)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/c-bata/goptuna"
+)
+
+// baselineAttrKey is a system attr of the trial of the default params of
+// -baseline.
+const baselineAttrKey = "baseline"
+
+// baselineParams are the default params of ffm-train, which -baseline
+// evaluates to compare the tuned ones with.
+func baselineParams(cfg *Config) map[string]interface{} {
+	var latent interface{} = 4
+	if cfg.LatentLog2 {
+		latent = "4"
+	}
+	return map[string]interface{}{"lambda": 0.00002, "eta": 0.2, "latent": latent}
+}
+
+// baselineComparison is the improvement of the best trial over the trial of
+// -baseline. A positive Improvement is better in the direction of the study.
+type baselineComparison struct {
+	Number             int     `json:"number"`
+	Value              float64 `json:"value"`
+	Improvement        float64 `json:"improvement"`
+	ImprovementPercent float64 `json:"improvement_percent"`
+}
+
+// enqueueBaseline enqueues the default params unless the study has a
+// completed trial of them. The params besides lambda, eta and latent are
+// sampled. It returns whether they're enqueued.
+func (r *runner) enqueueBaseline(study *goptuna.Study) (bool, error) {
+	trials, err := study.GetTrials()
+	if err != nil {
+		return false, err
+	}
+	for _, t := range trials {
+		if _, ok := t.SystemAttrs[baselineAttrKey]; ok && t.State == goptuna.TrialStateComplete {
+			return false, nil
+		}
+	}
+	params := baselineParams(r.cfg)
+	if r.buckets != nil {
+		if params, err = bucketParams(r.buckets, params); err != nil {
+			return false, err
+		}
+	}
+	dists := r.distributions()
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		// the sampler would silently sample a value out of the space.
+		if _, err = toInternalRepr(dists[name], params[name]); err != nil {
+			return false, fmt.Errorf("the default %s=%v is out of the search space: %s", name, params[name], err)
+		}
+	}
+	r.queue.Enqueue(params, map[string]string{baselineAttrKey: "true"})
+	return true, nil
+}
+
+// compareBaseline compares the best trial with the trial of -baseline, or
+// returns nil if the study has no completed one.
+func compareBaseline(study *goptuna.Study, best goptuna.FrozenTrial) (*baselineComparison, error) {
+	trials, err := study.GetTrials()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range trials {
+		if _, ok := t.SystemAttrs[baselineAttrKey]; !ok || t.State != goptuna.TrialStateComplete {
+			continue
+		}
+		c := &baselineComparison{Number: t.Number, Value: t.Value}
+		c.Improvement = t.Value - best.Value
+		if study.Direction() == goptuna.StudyDirectionMaximize {
+			c.Improvement = -c.Improvement
+		}
+		if t.Value != 0 {
+			c.ImprovementPercent = c.Improvement / math.Abs(t.Value) * 100
+		}
+		return c, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/c-bata/goptuna"
+	"github.com/c-bata/goptuna/rdb"
+	"github.com/jinzhu/gorm"
+)
+
+// bestResult is the summary of the best trial printed by -show-best and
+// written to -best-json.
+type bestResult struct {
+	Study  string                 `json:"study"`
+	Value  float64                `json:"value"`
+	Params map[string]interface{} `json:"params"`
+	Labels map[string]string      `json:"labels,omitempty"`
+	// EffectiveParams are the params which ffm-train ran with when
+	// Config.TransformParams changed them.
+	EffectiveParams map[string]interface{} `json:"effective_params,omitempty"`
+	// RunID is the run which evaluated the best trial.
+	RunID string `json:"run_id,omitempty"`
+	// Model is the header of the model retrained by -final-model.
+	Model *ModelInfo `json:"model,omitempty"`
+	// OverallBest is the best of all trials including the first
+	// Config.MinTrialsForBest ones, to compare with the best after them.
+	OverallBest *trialValue `json:"overall_best,omitempty"`
+	// Reevaluated are the top trials re-evaluated by -reeval-top-k, the
+	// best first.
+	Reevaluated []reevaluation `json:"reevaluated,omitempty"`
+	// Baseline compares the best trial with the default params of
+	// -baseline.
+	Baseline *baselineComparison `json:"baseline,omitempty"`
+	// Ties are the numbers of the trials with the same value as the best,
+	// including it, which -tie-break selected the best of.
+	Ties []int `json:"ties,omitempty"`
+}
+
+// trialValue is the number and the value of a trial.
+type trialValue struct {
+	Number int     `json:"number"`
+	Value  float64 `json:"value"`
+}
+
+// bestFilter returns the filter of the trials which can be the best: the ones
+// passing cfg.BestFilter after the first cfg.MinTrialsForBest trials.
+func bestFilter(cfg *Config) func(goptuna.FrozenTrial) bool {
+	if cfg.MinTrialsForBest <= 0 {
+		return cfg.BestFilter
+	}
+	return func(t goptuna.FrozenTrial) bool {
+		return t.Number >= cfg.MinTrialsForBest && (cfg.BestFilter == nil || cfg.BestFilter(t))
+	}
+}
+
+// LoadBestParams opens the storage at dsn and returns the best params and
+// value of the study without running any trials.
+func LoadBestParams(dsn, studyName string) (map[string]interface{}, float64, error) {
+	study, db, err := loadExistingStudy(dsn, studyName)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer db.Close()
+
+	best, err := getBestTrial(study, nil, tieBreakFirst)
+	if err != nil {
+		return nil, 0, err
+	}
+	return best.Params, best.Value, nil
+}
+
+// loadExistingStudy opens the storage at dsn and loads the study.
+// The returned DB must be closed by the caller.
+func loadExistingStudy(dsn, studyName string) (*goptuna.Study, *gorm.DB, error) {
+	db, err := openDB(dsn)
+	if err != nil {
+		return nil, nil, err
+	}
+	study, err := goptuna.LoadStudy(
+		studyName,
+		goptuna.StudyOptionStorage(rdb.NewStorage(db)),
+		goptuna.StudyOptionLogger(nil),
+	)
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return study, db, nil
+}
+
+// getBestTrial returns the best of the completed trials which pass the
+// filter, or all completed trials if filter is nil, breaking the ties of the
+// value by the policy of -tie-break. The trials are scanned instead of using
+// the best trial of the storage so that trials can be excluded. Diverged
+// trials and non-finite values are never the best.
+func getBestTrial(study *goptuna.Study, filter func(goptuna.FrozenTrial) bool, tieBreak string) (goptuna.FrozenTrial, error) {
+	trials, err := topTrials(study, filter, tieBreak, 1)
+	if err != nil {
+		return goptuna.FrozenTrial{}, err
+	}
+	return trials[0], nil
+}
+
+// topTrials returns the k best trials which can be the best by getBestTrial,
+// best first. It returns fewer if there aren't k of them.
+func topTrials(study *goptuna.Study, filter func(goptuna.FrozenTrial) bool, tieBreak string, k int) ([]goptuna.FrozenTrial, error) {
+	candidates, err := bestCandidates(study, filter)
+	if err != nil {
+		return nil, err
+	}
+	maximize := study.Direction() == goptuna.StudyDirectionMaximize
+	keys := make(map[int]int, len(candidates))
+	for _, t := range candidates {
+		keys[t.Number] = tieBreakKey(t, tieBreak)
+	}
+	// the earliest of the equal values and keys is the best, whatever order
+	// the storage returns the trials in.
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.Value != b.Value {
+			if maximize {
+				return a.Value > b.Value
+			}
+			return a.Value < b.Value
+		}
+		if keys[a.Number] != keys[b.Number] {
+			return keys[a.Number] < keys[b.Number]
+		}
+		return a.Number < b.Number
+	})
+	if len(candidates) > k {
+		candidates = candidates[:k]
+	}
+	return candidates, nil
+}
+
+// bestCandidates returns the completed trials which can be the best.
+func bestCandidates(study *goptuna.Study, filter func(goptuna.FrozenTrial) bool) ([]goptuna.FrozenTrial, error) {
+	trials, err := study.GetTrials()
+	if err != nil {
+		return nil, err
+	}
+	candidates := make([]goptuna.FrozenTrial, 0, len(trials))
+	for _, t := range trials {
+		if t.State != goptuna.TrialStateComplete || t.UserAttrs[divergedAttrKey] != "" || isDiverged(t.Value) {
+			continue
+		}
+		if filter != nil && !filter(t) {
+			continue
+		}
+		candidates = append(candidates, t)
+	}
+	if len(candidates) == 0 {
+		return nil, goptuna.ErrNoCompletedTrials
+	}
+	return candidates, nil
+}
+
+// getBestResult summarizes the best trial and the labels of the study.
+func getBestResult(study *goptuna.Study, cfg *Config) (bestResult, error) {
+	best, err := getBestTrial(study, bestFilter(cfg), cfg.TieBreak)
+	if err == goptuna.ErrNoCompletedTrials && cfg.MinTrialsForBest > 0 {
+		return bestResult{}, fmt.Errorf("no completed trials after the first %d trials of -min-trials-for-best", cfg.MinTrialsForBest)
+	}
+	if err != nil {
+		return bestResult{}, err
+	}
+	return newBestResult(study, cfg, best)
+}
+
+// newBestResult summarizes the trial as the best and the labels of the study.
+func newBestResult(study *goptuna.Study, cfg *Config, best goptuna.FrozenTrial) (bestResult, error) {
+	labels, err := study.GetUserAttrs()
+	if err != nil {
+		return bestResult{}, err
+	}
+	result := bestResult{
+		Study:  cfg.StudyName,
+		Value:  best.Value,
+		Params: best.Params,
+		Labels: labels,
+		RunID:  best.SystemAttrs[runIDAttrKey],
+	}
+	if _, ok := best.UserAttrs[effectiveParamsAttrKey]; ok {
+		if result.EffectiveParams, err = trialParams(best); err != nil {
+			return bestResult{}, err
+		}
+	}
+	if result.Baseline, err = compareBaseline(study, best); err != nil {
+		return bestResult{}, err
+	}
+	if result.Ties, err = tiedTrials(study, bestFilter(cfg), best); err != nil {
+		return bestResult{}, err
+	}
+	if cfg.MinTrialsForBest > 0 {
+		overall, err := getBestTrial(study, cfg.BestFilter, cfg.TieBreak)
+		if err != nil {
+			return bestResult{}, err
+		}
+		result.OverallBest = &trialValue{Number: overall.Number, Value: overall.Value}
+	}
+	return result, nil
+}
+
+// showBest prints the best params and value of the study as JSON.
+func showBest(cfg *Config) error {
+	study, db, err := loadExistingStudy(cfg.DSN, cfg.StudyName)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	result, err := getBestResult(study, cfg)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(result)
+}
+
+// writeBestJSON writes the summary of the best trial to path.
+func writeBestJSON(path string, result bestResult) error {
+	b, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
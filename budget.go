@@ -0,0 +1,36 @@
+package main
+
+import "sync/atomic"
+
+// trialBudget is the number of the trials which the workers of a sweep still
+// start, which the retries of -divergence-retry add to.
+type trialBudget struct {
+	n int64
+}
+
+func newTrialBudget(n int) *trialBudget {
+	return &trialBudget{n: int64(n)}
+}
+
+// Take takes a trial, or returns false if none is left. The budget never
+// goes below zero, so that the trials added later are still taken by the
+// workers which haven't exited yet.
+func (b *trialBudget) Take() bool {
+	for {
+		n := atomic.LoadInt64(&b.n)
+		if n <= 0 {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&b.n, n, n-1) {
+			return true
+		}
+	}
+}
+
+// Add adds n trials, if b isn't nil.
+func (b *trialBudget) Add(n int) {
+	if b == nil {
+		return
+	}
+	atomic.AddInt64(&b.n, int64(n))
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/c-bata/goptuna"
+)
+
+// checkDataChecksums stores the SHA-256 of the training and the validation
+// data as system attrs of the study when they're missing, and compares them
+// otherwise. Resuming a study on other data mixes trials whose values can't
+// be compared, which warns unless strict is set.
+func checkDataChecksums(study *goptuna.Study, cfg *Config, strict bool) error {
+	files := []struct {
+		key  string
+		path string
+	}{
+		{"train_sha256", cfg.TrainPath},
+		{"valid_sha256", cfg.ValidPath},
+	}
+	if cfg.RandomSplit > 0 {
+		// the validation data isn't used.
+		files = files[:1]
+	}
+	for _, f := range files {
+		start := time.Now()
+		hash, err := fileSHA256(f.path)
+		if err != nil {
+			return err
+		}
+		log.Printf("sha256 of %s is %s (took %s)", f.path, hash[:12], time.Since(start).Round(time.Millisecond))
+		old, err := setStudySystemAttrIfMissing(study, f.key, hash)
+		if err != nil {
+			return err
+		}
+		if old == "" || old == hash {
+			continue
+		}
+		msg := fmt.Sprintf("%s differs from the data of the stored trials: sha256 %s, but the study has %s", f.path, hash, old)
+		if strict {
+			return errors.New(msg)
+		}
+		log.Printf("WARNING: %s. The values of the new trials can't be compared with the stored ones", msg)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// commandLog is an append-only audit trail of the ffm-train and ffm-predict
+// commands. Each line is "<time> run_id=<id> trial=<number> <command>", where
+// trial is "-" for the commands run outside of trials. Lines are written
+// without buffering and synced, so they survive a crash of the process.
+type commandLog struct {
+	runID string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+func openCommandLog(path, runID string) (*commandLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the command log: %s", err)
+	}
+	return &commandLog{runID: runID, f: f}, nil
+}
+
+// Log records a command. trial is the trial number, or -1. A nil log records
+// nothing, so the callers don't need to check whether it's enabled.
+func (l *commandLog) Log(trial int, bin string, args []string) error {
+	if l == nil {
+		return nil
+	}
+	number := "-"
+	if trial >= 0 {
+		number = strconv.Itoa(trial)
+	}
+	line := fmt.Sprintf("%s run_id=%s trial=%s %s\n",
+		time.Now().Format(time.RFC3339Nano), l.runID, number,
+		shellJoin(append([]string{bin}, args...)))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.f.WriteString(line); err != nil {
+		return fmt.Errorf("failed to write the command log: %s", err)
+	}
+	return l.f.Sync()
+}
+
+func (l *commandLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.f.Close()
+}
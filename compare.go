@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/c-bata/goptuna"
+	"github.com/c-bata/goptuna/rdb"
+)
+
+// compareStudies prints the best value and params of cfg.CompareStudy and
+// cfg.StudyName side by side, and whether the latter improved.
+func compareStudies(cfg *Config) error {
+	db, err := openDB(cfg.DSN)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	storage := rdb.NewStorage(db)
+
+	var bests [2]goptuna.FrozenTrial
+	var directions [2]goptuna.StudyDirection
+	for i, name := range []string{cfg.CompareStudy, cfg.StudyName} {
+		study, err := goptuna.LoadStudy(
+			name,
+			goptuna.StudyOptionStorage(storage),
+			goptuna.StudyOptionLogger(nil),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to load study %q: %s", name, err)
+		}
+		bests[i], err = getBestTrial(study, bestFilter(cfg), cfg.TieBreak)
+		if err != nil {
+			return fmt.Errorf("failed to get the best trial of %q: %s", name, err)
+		}
+		directions[i] = study.Direction()
+	}
+	if directions[0] != directions[1] {
+		return fmt.Errorf("studies have different directions: %s and %s", directions[0], directions[1])
+	}
+
+	names := make([]string, 0, len(bests[1].Params))
+	for k := range bests[0].Params {
+		names = append(names, k)
+	}
+	for k := range bests[1].Params {
+		if _, ok := bests[0].Params[k]; !ok {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "\t%s\t%s\n", cfg.CompareStudy, cfg.StudyName)
+	fmt.Fprintf(w, "value\t%v\t%v\n", bests[0].Value, bests[1].Value)
+	for _, k := range names {
+		fmt.Fprintf(w, "%s\t%v\t%v\n", k, formatParam(bests[0].Params, k), formatParam(bests[1].Params, k))
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+
+	delta := bests[1].Value - bests[0].Value
+	improved := delta < 0
+	if directions[1] == goptuna.StudyDirectionMaximize {
+		improved = delta > 0
+	}
+	fmt.Printf("improved: %t (delta=%+.6g)\n", improved, delta)
+	return nil
+}
+
+func formatParam(params map[string]interface{}, name string) string {
+	v, ok := params[name]
+	if !ok {
+		return "-"
+	}
+	return fmt.Sprintf("%v", v)
+}
@@ -0,0 +1,930 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c-bata/goptuna"
+)
+
+// Config holds the settings of a sweep.
+type Config struct {
+	// DSN is the path of the SQLite3 database.
+	DSN string
+	// StudyName is the name of the study in the storage.
+	StudyName string
+	// TrainBin is the path of the ffm-train binary.
+	TrainBin string
+	// PredictBin is the path of the ffm-predict binary. It defaults to the
+	// ffm-predict in the directory of TrainBin.
+	PredictBin string
+	// TrainPath is the path of the training data in libffm format.
+	TrainPath string
+	// ValidPath is the path of the validation data in libffm format.
+	ValidPath string
+	// NTrials is the number of trials to run.
+	NTrials int
+	// Concurrency is the number of workers running trials in parallel.
+	Concurrency int
+	// Seed is the seed of the sampler. Each trial is sampled with a random
+	// state derived from it and the trial number.
+	Seed int64
+	// TrainSeedFlag is the flag of ffm-train to set the seed of shuffling,
+	// for libffm forks which accept it. Stock libffm has no such flag.
+	TrainSeedFlag string
+	// Metric is the objective value: ffm-train's best validation loss, or a
+	// metric computed from ffm-predict's output.
+	Metric string
+	// CreateIfMissing creates the study when it isn't found in the storage.
+	CreateIfMissing bool
+	// FailIfExists refuses to run against a study which already exists.
+	FailIfExists bool
+	// LatentLog2 samples latent from the powers of two in [1, 16].
+	LatentLog2 bool
+	// ResumeIncomplete re-runs the params of failed or stale running trials.
+	ResumeIncomplete bool
+	// ShowBest prints the best trial of the study and exits without optimizing.
+	ShowBest bool
+	// CompareStudy is the name of a study to compare the best trial with,
+	// then exits without optimizing.
+	CompareStudy string
+	// SummarizeData scans the training data at startup to log and store the
+	// number of fields, features and examples.
+	SummarizeData bool
+	// PrecomputeBin converts the data into libffm's binary format once and
+	// lets every trial read it with --on-disk.
+	PrecomputeBin bool
+	// LatentPenalty is added to the objective value per latent factor, to
+	// prefer smaller models.
+	LatentPenalty float64
+	// MultiObjective reports the Pareto front of validation loss and latent
+	// after the sweep. goptuna has no multi-objective study, so the sampler
+	// still optimizes the (optionally penalized) validation loss.
+	MultiObjective bool
+	// ParetoJSON is the path to write the Pareto front after the sweep.
+	ParetoJSON string
+	// WarmPool trains every trial on a persistent "ffm-train --server"
+	// process instead of starting ffm-train per trial. A trial timed out by
+	// TrialTimeout sends a cancel request to the server.
+	WarmPool bool
+	// Labels are stored as user attrs of the study for bookkeeping.
+	Labels map[string]string
+	// BestJSON is the path to write the best trial after the sweep.
+	BestJSON string
+	// CSV is the path to export the finished trials after the sweep.
+	CSV string
+	// CSVAppend appends the trials newer than the last row of the existing
+	// CSV instead of rewriting it.
+	CSVAppend bool
+	// RunID identifies this invocation in the logs, the storage and the
+	// exported artifacts. A random UUID is generated if empty.
+	RunID string
+	// CheckSchema fails before running trials if the storage was created by
+	// an incompatible goptuna version.
+	CheckSchema bool
+	// Constraints reject known-invalid combinations of the sampled params
+	// ("lambda", "eta" and "latent"). A trial is pruned without running
+	// ffm-train if any of them returns false.
+	Constraints []func(params map[string]interface{}) bool
+	// TransformParams maps the sampled params ("lambda", "eta" and "latent")
+	// to the values ffm-train runs with, e.g. to quantize or couple them. The
+	// transformed params are stored as the "effective_params" user attr, and
+	// Constraints are checked against them.
+	TransformParams func(params map[string]interface{}) map[string]interface{}
+	// ValueTransform maps the objective value to the stored one, e.g.
+	// math.Exp for the perplexity of the logloss. It must be increasing to
+	// keep the direction of the study. The value before the transform is
+	// stored as the "untransformed_value" user attr.
+	ValueTransform func(value float64) float64
+	// BestFilter selects the completed trials which can be the best, e.g. to
+	// exclude trials of another training data. nil means all of them.
+	BestFilter func(trial goptuna.FrozenTrial) bool
+	// Trainer trains the trials instead of ffm-train if set. The flags of
+	// ffm-train's runs, like -repeats, -cache-dir and -pin-cores, don't apply
+	// to it.
+	Trainer Trainer
+	// AutoScale narrows the ranges of eta and lambda by the number of
+	// training examples.
+	AutoScale bool
+	// WarmStart is the path of a JSON array of params evaluated by the first
+	// trials.
+	WarmStart string
+	// LatentBuckets is the path of a JSON array of the ranges of eta and lambda
+	// by latent. Latent is sampled first, and eta and lambda are sampled as the
+	// params of its bucket, e.g. "eta_k4", so the stored params and the CSV
+	// columns follow the buckets. The effective_params attr has them as
+	// lambda and eta.
+	LatentBuckets string
+	// Normalize reports the relative improvement of logloss over a constant
+	// predictor of the validation label rate, which is maximized.
+	Normalize bool
+	// AutosaveEvery retrains the best trial and writes the model to
+	// AutosavePath every K finished trials if the best has changed.
+	AutosaveEvery int
+	// AutosavePath is the path of the autosaved model. {trial} and {value}
+	// are replaced with the number and the value of the trial.
+	AutosavePath string
+	// FinalModel is the path to write the model retrained with the best
+	// trial after the sweep, with the same placeholders as AutosavePath.
+	FinalModel string
+	// LeaderElection lets only one of the workers sharing RunID retrain and
+	// report after the sweep. The others exit after their trials. The
+	// workers share the SQLite file of DSN, so they run on the same host:
+	// SQLite doesn't lock reliably on a network filesystem, and the storage
+	// has no other dialect.
+	LeaderElection bool
+	// Repeats is the number of ffm-train runs per trial with consecutive
+	// seeds. The trial reports the mean of them.
+	Repeats int
+	// Export is the path to write all trials of the study as JSON lines
+	// without running trials.
+	Export string
+	// ExportOptuna is the path of a new SQLite database to write the study
+	// into in the schema of Optuna's RDB storage, which optuna-dashboard opens.
+	ExportOptuna string
+	// CheckLeakage counts the validation lines which also appear in the
+	// training data before running trials.
+	CheckLeakage bool
+	// LeakageThreshold is the ratio of the overlapping validation lines
+	// above which -check-leakage warns.
+	LeakageThreshold float64
+	// NoSignalHandler lets RunStudy rely only on its context for cancellation
+	// instead of handling SIGINT, SIGTERM and SIGQUIT, for programs which
+	// manage the signals by themselves.
+	NoSignalHandler bool
+	// ProfileStorage measures the latency of the storage writes and reports
+	// it after the sweep.
+	ProfileStorage bool
+	// PenalizeDivergence completes a trial whose loss is NaN or infinite with
+	// the worst value instead of failing it, so that TPE avoids its region.
+	PenalizeDivergence bool
+	// CompressOutput stores the stdout and stderr attrs gzipped and
+	// base64-encoded with the "gzip+base64:" prefix.
+	CompressOutput bool
+	// CommandLog is a file to append every ffm-train and ffm-predict command
+	// to, with the time and the trial number, across runs.
+	CommandLog string
+	// AUCExactLimit is the number of validation examples up to which AUC is
+	// computed exactly. Larger sets are approximated in constant memory.
+	AUCExactLimit int
+	// ParamPrecision is the number of significant digits of lambda and eta
+	// passed to ffm-train.
+	ParamPrecision int
+	// Backup is a file to snapshot all trials of the study to as JSON every
+	// BackupInterval and after the sweep, in case the storage is corrupted.
+	Backup         string
+	BackupInterval time.Duration
+	// TUI redraws the progress of the study on the terminal instead of
+	// logging each trial. It falls back to logging if stdout isn't a terminal.
+	TUI bool
+	// ProbeJSONMeta runs a tiny training before the trials to check that
+	// ffm-train writes the fields of the JSON meta which the objective reads.
+	ProbeJSONMeta bool
+	// CacheDir is a directory to cache the evaluations in, so that the runs
+	// with the same params on unchanged data and binaries are skipped.
+	CacheDir string
+	// FeatureMap is the path of a dictionary of the field and feature
+	// indices, by which the model summaries name them.
+	FeatureMap string
+	// MaxMemoryMB prunes the trials whose ffm-train is predicted to use more
+	// memory from the max RSS of the finished trials, or 0 to disable.
+	MaxMemoryMB float64
+	// SamplerWindow limits the observations of TPE to the most recent
+	// finished trials, so that it follows the current regime of
+	// non-stationary data, or 0 to use all trials. A smaller window adapts
+	// faster but estimates the good and bad regions from fewer trials, so
+	// the suggestions are noisier and good regions found earlier are
+	// forgotten.
+	SamplerWindow int
+	// Explain prints the params ranked by their importance for the value of
+	// the completed trials, then exits without optimizing.
+	Explain bool
+	// Wrapper is a command with its args which the libffm binaries are run
+	// under, like "nice -n 19" or "taskset -c 0-3". It must exec the binary
+	// in place, as those do, so that cancelling a trial kills ffm-train.
+	Wrapper string
+	// SearchSpaceCheck is what to do when the stored trials have params
+	// outside the current search space, e.g. after narrowing the ranges of a
+	// resumed study: "warn", "error" or "off".
+	SearchSpaceCheck string
+	// GenScript is the path to write a shell script which retrains the best
+	// trial after the sweep.
+	GenScript string
+	// OKExitCodes are the exit codes of ffm-train which mean success. libffm
+	// exits with 1 when early stopping stops the training, and forks differ.
+	OKExitCodes map[int]bool
+	// Version prints the versions of goptuna-libffm, goptuna and libffm,
+	// then exits.
+	Version bool
+	// EtaDecayFlag is the ffm-train flag of the decay of the learning rate in
+	// libffm forks with schedules, like "--eta-decay". When set, the decay is
+	// tuned as the eta_decay param in [EtaDecayMin, EtaDecayMax].
+	EtaDecayFlag string
+	EtaDecayMin  float64
+	EtaDecayMax  float64
+	// DBLock is what to do when another process sweeps on the database:
+	// "error", "wait" for it, or "off" to share the database. It doesn't
+	// apply with LeaderElection, whose workers share the database.
+	DBLock string
+	// RecordMetrics are the metrics computed from ffm-predict's output and
+	// stored as user attrs besides the objective metric, like brier and ece.
+	RecordMetrics []string
+	// ECEBins is the number of the bins of the ece metric.
+	ECEBins int
+	// InitDB creates the schema and the study, then exits without
+	// optimizing.
+	InitDB bool
+	// MaxLoad throttles the trials run at once while the 1-minute load
+	// average of the machine is above it, or 0 to always run Concurrency
+	// trials. It requires /proc/loadavg.
+	MaxLoad float64
+	// PlausibleMin and PlausibleMax are the range of the metric of a trial,
+	// the mean of its repeats, outside which it fails with the
+	// implausible_value attr instead of recording the value. Both 0 disable
+	// the check.
+	PlausibleMin float64
+	PlausibleMax float64
+	// SelectFields tunes which fields of the data to train with, as a "0" or
+	// "1" param per field, e.g. field_3. The trials train on copies of the data
+	// with the selected fields, which are shared by the same selections.
+	SelectFields bool
+	// EventSocket is the path of a Unix domain socket to listen on. The
+	// finished trials are pushed to its clients as JSON lines like -export.
+	EventSocket string
+	// MinTrialsForBest excludes the first trials from the best, which are
+	// sampled randomly and can win by a lucky value on a noisy objective.
+	MinTrialsForBest int
+	// ReevalTopK re-evaluates the best K trials after the study, and the one
+	// with the best mean metric over ReevalRepeats runs becomes the best.
+	ReevalTopK int
+	// ReevalRepeats is the number of the runs of each trial of ReevalTopK,
+	// with the seeds after the ones of Repeats.
+	ReevalRepeats int
+	// SummaryJSON is the path of a small JSON file with the best params,
+	// value and best iteration, which is replaced whenever the best changes.
+	SummaryJSON string
+	// ContinueOnError keeps running the trials after a trial failed. Unless
+	// it's set, the first failed trial cancels the others and the study.
+	ContinueOnError bool
+	// PinCores is a list of cores like "0-7" to split among the workers, so
+	// that each ffm-train run has dedicated cores for stable timing. It's only
+	// supported on Linux with taskset.
+	PinCores string
+	// Artifact is the path of a tar.gz bundling the model of FinalModel, its
+	// params, the script of GenScript and a manifest.
+	Artifact string
+	// RandomSplit is the ratio of the examples of TrainPath held out for
+	// validation by a random split per trial, seeded by the trial number.
+	// ValidPath isn't used by the trials then, and the final model is
+	// retrained on all of TrainPath.
+	RandomSplit float64
+	// Sampler is the sampler of the params: "tpe", or "grid" to evaluate
+	// every point of Grid once instead of NTrials trials.
+	Sampler string
+	// Grid is the path of a JSON object of the values of every param for
+	// the grid sampler, e.g. {"lambda": [1e-5, 1e-4], "eta": [0.1], ...}.
+	Grid string
+	// DataChecksums stores the SHA-256 of the data as study attrs and warns
+	// if they differ on resume. StrictDataChecksums makes it an error.
+	DataChecksums       bool
+	StrictDataChecksums bool
+	// Once evaluates OnceParams by a single trial in memory and prints it,
+	// without the storage.
+	Once       bool
+	OnceParams map[string]string
+	// MaxArtifactBytes caps the total size of the models, meta and
+	// predictions of the trials under ./data/optuna by deleting the oldest
+	// ones but the best trial's.
+	MaxArtifactBytes int64
+	// TrainEnv are the environment variables added to the inherited ones of
+	// ffm-train, for the forks configured by the environment.
+	TrainEnv map[string]string
+	// GenSynthetic writes synthetic training and validation data with
+	// SyntheticExamples examples of SyntheticFields fields, each with
+	// SyntheticFeatures features, from DataSeed and exits.
+	GenSynthetic      bool
+	SyntheticExamples int
+	SyntheticFields   int
+	SyntheticFeatures int
+	// ControlFile pauses starting new trials while the file exists.
+	ControlFile string
+	// Journal is the file of Optuna's JournalStorage to append the finished
+	// trials to, or empty.
+	Journal string
+	// MinFreeDisk stops the sweep when the free disk space of ./data/optuna
+	// falls below the bytes, or 0 to disable it.
+	MinFreeDisk int64
+	// GroupFile has the group of each validation example, e.g. the query or
+	// the user, a line per example, for the ranking metrics. RankK is the K
+	// of precision_at_k and recall_at_k.
+	GroupFile string
+	RankK     int
+	// DivergenceRetry prunes a diverged trial and enqueues its params with
+	// the halved eta as a new trial, besides NTrials, up to the times in a
+	// row, unless TransformParams is set.
+	DivergenceRetry int
+	// TrialTimeout stops the runs of a trial after the duration, or 0 for no
+	// timeout. TimeoutAction fails such a trial, or prunes it with the best
+	// va_logloss before the timeout.
+	TrialTimeout  time.Duration
+	TimeoutAction string
+	// Baseline evaluates the default params of ffm-train first and reports
+	// the improvement of the best trial over them.
+	Baseline bool
+	// ArtifactStore stores the meta, the model, the predictions and the
+	// output of each run, e.g. s3://bucket/prefix, besides ./data/optuna.
+	ArtifactStore string
+	// ParamsFromTrial re-runs the params of the trial of this number in memory
+	// like Once and compares the values, unless it's -1, since 0 is a trial
+	// number too.
+	ParamsFromTrial int
+	// Serve serves the HTTP API to run sweeps on this address, like
+	// "127.0.0.1:8080".
+	Serve string
+	// SplitFolds writes this many train/valid folds of the training data to
+	// FoldDir by SplitKFold and exits, if positive.
+	SplitFolds int
+	FoldDir    string
+	// TieBreak is the policy selecting the best of the trials with the same
+	// best value: tieBreakFirst, tieBreakLatent, tieBreakIteration or
+	// tieBreakFail.
+	TieBreak string
+	// DataSeed is the seed of the data helpers, like the splits of
+	// -random-split and -split-folds and the synthetic data, so that the data
+	// stays fixed while Seed varies the search, or vice versa. It defaults to
+	// Seed.
+	DataSeed int64
+	// MetricWorkers is the number of the goroutines computing the metrics
+	// of ffm-predict's output in parallel. The ranking metrics are computed
+	// by one.
+	MetricWorkers int
+	// AnnotateTrials backfills the user attrs which the finished trials of
+	// the study lack by annotateTrials and exits.
+	AnnotateTrials bool
+	// MaxProcs caps the ffm-train processes running at once, unlike
+	// Concurrency which is the number of the trials, if positive.
+	MaxProcs int
+	// WarmCache reads the data once before the trials, so that the first
+	// trials don't read it from the disk while the later ones read it from
+	// the page cache.
+	WarmCache bool
+
+	// dataSeedSet reports whether -data-seed was given, or else DataSeed is
+	// Seed, which -serve derives again from the Seed of a sweep.
+	dataSeedSet bool
+}
+
+func parseFlags(args []string) (*Config, error) {
+	cfg, err := parseArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if err = completeConfig(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// parseArgs returns the config of the defaults, the environment variables
+// and the flags, before completeConfig.
+func parseArgs(args []string) (*Config, error) {
+	cfg := &Config{
+		DSN:         "db.sqlite3",
+		StudyName:   "goptuna-libffm",
+		TrainBin:    "./ffm-train",
+		TrainPath:   "./data/train2.txt",
+		ValidPath:   "./data/valid2.txt",
+		NTrials:     1000,
+		Metric:      metricVALoss,
+		Concurrency: defaultConcurrency(),
+		Labels:      make(map[string]string),
+		OnceParams:  make(map[string]string),
+		TrainEnv:    make(map[string]string),
+		OKExitCodes: map[int]bool{0: true, 1: true},
+	}
+	// environment variables override the defaults, and flags override both.
+	if err := applyEnv(cfg); err != nil {
+		return nil, err
+	}
+
+	fs := flag.NewFlagSet("goptuna-libffm", flag.ContinueOnError)
+	fs.StringVar(&cfg.DSN, "dsn", cfg.DSN, "path of the SQLite3 database (env: FFM_DB_DSN)")
+	fs.StringVar(&cfg.StudyName, "study", cfg.StudyName, "name of the study (env: FFM_STUDY)")
+	fs.StringVar(&cfg.TrainBin, "ffm-train-bin", cfg.TrainBin,
+		"path of the ffm-train binary (env: FFM_TRAIN_PATH)")
+	fs.StringVar(&cfg.PredictBin, "ffm-predict-bin", "",
+		"path of the ffm-predict binary (default: ffm-predict next to -ffm-train-bin)")
+	fs.IntVar(&cfg.NTrials, "n-trials", cfg.NTrials, "number of trials (env: FFM_N_TRIALS)")
+	fs.IntVar(&cfg.Concurrency, "concurrency", cfg.Concurrency,
+		"number of trials running in parallel (env: FFM_CONCURRENCY)")
+	fs.Int64Var(&cfg.Seed, "seed", 0,
+		"seed of the sampler; a resumed study continues the same trajectory when trials run one at a time")
+	fs.StringVar(&cfg.TrainSeedFlag, "train-seed-flag", "",
+		"flag of ffm-train to pass a per-trial seed derived from -seed (e.g. --seed)")
+	fs.StringVar(&cfg.Metric, "metric", cfg.Metric,
+		"objective metric: va_loss (reported by ffm-train), logloss, auc, brier, ece, rmse, mae, precision_at_k or recall_at_k (computed by ffm-predict)")
+	fs.BoolVar(&cfg.CreateIfMissing, "create-if-missing", true,
+		"create the study if it doesn't exist yet")
+	fs.BoolVar(&cfg.FailIfExists, "fail-if-exists", false,
+		"exit with an error if the study already exists")
+	fs.BoolVar(&cfg.LatentLog2, "latent-log2", false,
+		"sample latent from powers of two (1, 2, 4, 8, 16) instead of every integer")
+	fs.BoolVar(&cfg.ResumeIncomplete, "resume-incomplete", false,
+		"re-run the params of failed or stale running trials in addition to the new trials")
+	fs.BoolVar(&cfg.ShowBest, "show-best", false,
+		"print the best params and value of the study, then exit")
+	fs.StringVar(&cfg.CompareStudy, "compare-study", "",
+		"compare the best trial with the one of this study, then exit")
+	fs.BoolVar(&cfg.SummarizeData, "summarize-data", true,
+		"scan the training data at startup and report the number of fields, features and examples")
+	fs.BoolVar(&cfg.PrecomputeBin, "precompute-bin", false,
+		"convert the data into libffm's binary format before the sweep and train with --on-disk")
+	fs.Float64Var(&cfg.LatentPenalty, "latent-penalty", 0,
+		"add this coefficient times latent to the validation loss to prefer smaller models")
+	fs.BoolVar(&cfg.MultiObjective, "multi-objective", false,
+		"report the Pareto front of validation loss and latent after the sweep")
+	fs.StringVar(&cfg.ParetoJSON, "pareto-json", "",
+		"write the Pareto front to this JSON file (implies -multi-objective)")
+	fs.BoolVar(&cfg.WarmPool, "warm-pool", false,
+		"keep one \"ffm-train --server\" process alive for all trials (requires a compatible libffm fork)")
+	fs.Var(keyValueFlag(cfg.Labels), "label",
+		"label the study with key=value (repeatable)")
+	fs.StringVar(&cfg.BestJSON, "best-json", "",
+		"write the best params, value and labels to this JSON file after the sweep")
+	fs.StringVar(&cfg.CSV, "csv", "",
+		"export the finished trials to this CSV file after the sweep")
+	fs.BoolVar(&cfg.CSVAppend, "csv-append", false,
+		"append only the trials newer than the last row of the existing -csv file")
+	fs.StringVar(&cfg.RunID, "run-id", "",
+		"ID of this run to correlate the logs (default a random UUID)")
+	fs.BoolVar(&cfg.CheckSchema, "check-schema", true,
+		"fail fast if the storage schema is from an incompatible goptuna version")
+	fs.BoolVar(&cfg.AutoScale, "auto-scale", false,
+		"narrow the ranges of eta and lambda by the number of training examples")
+	fs.StringVar(&cfg.WarmStart, "warm-start", "",
+		"evaluate a JSON array of params like {\"lambda\": 2e-5, \"eta\": 0.2, \"latent\": 4} first")
+	fs.StringVar(&cfg.LatentBuckets, "latent-buckets", "",
+		"JSON file of the ranges of lambda and eta by latent, e.g. [{\"max_latent\": 4, \"lambda\": [1e-6, 1e-2], \"eta\": [1e-3, 1]}]")
+	fs.BoolVar(&cfg.Normalize, "normalize", false,
+		"report the relative improvement of logloss over a constant predictor")
+	fs.IntVar(&cfg.AutosaveEvery, "autosave-every", 0,
+		"retrain and save the best model every K finished trials (0 to disable)")
+	fs.StringVar(&cfg.AutosavePath, "autosave-model", "./ffm-best.model",
+		"path of the model written by -autosave-every, where {trial} and {value} are replaced")
+	fs.StringVar(&cfg.FinalModel, "final-model", "",
+		"retrain the best trial and write the model to this path after the sweep, where {trial} and {value} are replaced")
+	fs.BoolVar(&cfg.LeaderElection, "leader-election", false,
+		"let only one of the workers sharing -run-id and the SQLite file of -dsn on this host retrain and report after the sweep")
+	fs.IntVar(&cfg.Repeats, "repeats", 1,
+		"train N times per trial with different seeds and report the mean (requires -train-seed-flag if N > 1)")
+	fs.StringVar(&cfg.Export, "export", "",
+		"write all trials of the study to this file as JSON lines and exit")
+	fs.StringVar(&cfg.ExportOptuna, "export-optuna", "",
+		"write the study into a new SQLite database of Optuna's schema for optuna-dashboard and exit")
+	fs.BoolVar(&cfg.CheckLeakage, "check-leakage", false,
+		"count the validation lines which also appear in the training data before running trials")
+	fs.Float64Var(&cfg.LeakageThreshold, "leakage-threshold", 0,
+		"warn if the ratio of the overlapping validation lines exceeds this")
+	fs.BoolVar(&cfg.NoSignalHandler, "no-signal-handler", false,
+		"don't handle SIGINT, SIGTERM and SIGQUIT to cancel the trials")
+	fs.BoolVar(&cfg.ProfileStorage, "profile-storage", false,
+		"measure the latency of the storage writes and compare it with ffm-train")
+	fs.BoolVar(&cfg.PenalizeDivergence, "penalize-divergence", false,
+		"complete diverged trials with the worst value instead of failing them, to steer TPE away")
+	fs.BoolVar(&cfg.CompressOutput, "compress-output", false,
+		"store the stdout and stderr attrs gzipped and base64-encoded with a \"gzip+base64:\" prefix")
+	fs.StringVar(&cfg.CommandLog, "command-log", "",
+		"append every ffm-train and ffm-predict command with the time and the trial number to the file")
+	fs.IntVar(&cfg.AUCExactLimit, "auc-exact-limit", 10000000,
+		"compute AUC exactly up to this number of validation examples and approximate it by a histogram beyond")
+	fs.IntVar(&cfg.ParamPrecision, "param-precision", 10,
+		"number of significant digits of lambda and eta passed to ffm-train")
+	fs.StringVar(&cfg.Backup, "backup", "",
+		"snapshot all trials of the study to this JSON file periodically and after the sweep")
+	fs.DurationVar(&cfg.BackupInterval, "backup-interval", 10*time.Minute,
+		"interval of the -backup snapshots")
+	fs.BoolVar(&cfg.TUI, "tui", false,
+		"show the live progress of the study on the terminal instead of logging each trial")
+	fs.BoolVar(&cfg.ProbeJSONMeta, "probe-json-meta", true,
+		"check by a tiny training that ffm-train writes the JSON meta which the objective reads")
+	fs.StringVar(&cfg.CacheDir, "cache-dir", "",
+		"cache the evaluations in this directory and skip ffm-train for the same params on unchanged data and binaries")
+	fs.StringVar(&cfg.FeatureMap, "feature-map", "",
+		"file of lines \"field <index> <name>\" or \"feature <index> <name>\" to name the indices in the model summaries")
+	fs.Float64Var(&cfg.MaxMemoryMB, "max-memory-mb", 0,
+		"prune trials whose ffm-train is predicted by latent to use more memory than this (0 to disable)")
+	fs.IntVar(&cfg.SamplerWindow, "sampler-window", 0,
+		"sample by TPE only from the most recent N finished trials to adapt to non-stationary data (0 to use all)")
+	fs.BoolVar(&cfg.Explain, "explain", false,
+		"print the params ranked by a rough importance estimated from the completed trials, then exit")
+	fs.StringVar(&cfg.Wrapper, "wrapper", "",
+		"run ffm-train and ffm-predict under this command split on spaces, like \"nice -n 19\" or \"taskset -c 0-3\"")
+	fs.StringVar(&cfg.SearchSpaceCheck, "search-space-check", searchSpaceCheckWarn,
+		"warn, error or off when stored trials have params outside the current search space")
+	fs.StringVar(&cfg.GenScript, "gen-script", "",
+		"write an executable shell script which retrains the best trial by ffm-train to this path")
+	fs.Var(intSetFlag(cfg.OKExitCodes), "ok-exit-codes",
+		"comma-separated exit codes of ffm-train which mean success; any other code fails the run")
+	fs.BoolVar(&cfg.Version, "version", false,
+		"print the versions of goptuna-libffm, goptuna and libffm, then exit")
+	fs.StringVar(&cfg.EtaDecayFlag, "eta-decay-flag", "",
+		"ffm-train flag of the learning-rate decay of a libffm fork, which enables tuning it as eta_decay")
+	fs.Float64Var(&cfg.EtaDecayMin, "eta-decay-min", 0.5, "lower bound of eta_decay")
+	fs.Float64Var(&cfg.EtaDecayMax, "eta-decay-max", 1, "upper bound of eta_decay")
+	fs.StringVar(&cfg.DBLock, "db-lock", dbLockError,
+		"error, wait or off when another process sweeps on the SQLite database")
+	fs.Var((*stringListFlag)(&cfg.RecordMetrics), "record-metrics",
+		"comma-separated metrics like brier,ece to record as user attrs besides -metric, which must use ffm-predict")
+	fs.IntVar(&cfg.ECEBins, "ece-bins", 10, "number of the equal-width bins of the ece metric")
+	fs.BoolVar(&cfg.InitDB, "init-db", false,
+		"create the storage schema and the study with the direction of -metric if missing, then exit")
+	fs.Float64Var(&cfg.MaxLoad, "max-load", 0,
+		"run fewer trials at once while the load average is above this, e.g. the number of CPUs (0 to disable)")
+	fs.Float64Var(&cfg.PlausibleMin, "plausible-min", 0,
+		"fail the trials whose metric is below this as implausible (disabled if both bounds are 0)")
+	fs.Float64Var(&cfg.PlausibleMax, "plausible-max", 0,
+		"fail the trials whose metric is above this as implausible (disabled if both bounds are 0)")
+	fs.BoolVar(&cfg.SelectFields, "select-fields", false,
+		fmt.Sprintf("tune which fields to train with as a field_<index> param each (up to %d fields)", maxSelectableFields))
+	fs.StringVar(&cfg.EventSocket, "event-socket", "",
+		"path of a Unix domain socket to push the finished trials to its clients as JSON lines")
+	fs.IntVar(&cfg.MinTrialsForBest, "min-trials-for-best", 0,
+		fmt.Sprintf("select the best among the trials after the first N, e.g. %d of the random startup (0 to disable)", tpeStartupTrials))
+	fs.IntVar(&cfg.ReevalTopK, "reeval-top-k", 0,
+		"re-evaluate the best K trials after the study and select the best by the mean metric (0 to disable)")
+	fs.IntVar(&cfg.ReevalRepeats, "reeval-repeats", 3,
+		"number of the ffm-train runs with new seeds of each trial of -reeval-top-k")
+	fs.StringVar(&cfg.SummaryJSON, "summary-json", "",
+		"path of a JSON summary of the best trial like study-summary.json, replaced whenever the best changes")
+	fs.BoolVar(&cfg.ContinueOnError, "continue-on-error", true,
+		"keep running the trials after a trial failed; false aborts the study on the first failure")
+	fs.StringVar(&cfg.PinCores, "pin-cores", "",
+		"cores like 0-7 to split among the workers to pin ffm-train to with taskset (Linux only)")
+	fs.StringVar(&cfg.Artifact, "artifact", "",
+		"path of a tar.gz of the -final-model, its params.json, train.sh and a manifest.json")
+	fs.Float64Var(&cfg.RandomSplit, "random-split", 0,
+		"hold out this ratio of the training data for validation by a random split per trial instead of the validation data (0 to disable)")
+	fs.StringVar(&cfg.Sampler, "sampler", samplerTPE,
+		"sampler of the params: tpe, or grid to run every point of -grid instead of -n-trials trials")
+	fs.StringVar(&cfg.Grid, "grid", "",
+		`path of a JSON object of the values of every param for -sampler grid, e.g. {"lambda": [1e-5, 1e-4], ...}`)
+	fs.BoolVar(&cfg.DataChecksums, "data-checksums", false,
+		"store the sha256 of the data in the study and warn if a resumed study has other data")
+	fs.BoolVar(&cfg.StrictDataChecksums, "strict-data-checksums", false,
+		"like -data-checksums, but fail if a resumed study has other data")
+	fs.BoolVar(&cfg.Once, "once", false,
+		"evaluate the params of -param by a single trial without the storage, print it and exit")
+	fs.Var(keyValueFlag(cfg.OnceParams), "param",
+		"param=value of -once, repeatable like -param lambda=0.001 -param eta=0.01 -param latent=8")
+	fs.Int64Var(&cfg.MaxArtifactBytes, "max-artifact-bytes", 0,
+		"delete the oldest files of the trials but the best's when they exceed this size in bytes (0 to disable)")
+	fs.Var(keyValueFlag(cfg.TrainEnv), "env",
+		"KEY=VALUE of an environment variable of ffm-train on top of the inherited ones, repeatable")
+	fs.BoolVar(&cfg.GenSynthetic, "gen-synthetic", false,
+		"write synthetic training and validation data with a known signal from -data-seed, then exit")
+	fs.IntVar(&cfg.SyntheticExamples, "synthetic-examples", 1000,
+		"number of the training examples of -gen-synthetic, besides a quarter as many validation examples")
+	fs.IntVar(&cfg.SyntheticFields, "synthetic-fields", 4, "number of the fields of -gen-synthetic")
+	fs.IntVar(&cfg.SyntheticFeatures, "synthetic-features", 10, "number of the features per field of -gen-synthetic")
+	fs.StringVar(&cfg.ControlFile, "control-file", "",
+		"don't start new trials while this file exists, so that touching it pauses the sweep and removing it resumes")
+	fs.StringVar(&cfg.Journal, "journal", "",
+		"append the finished trials to this file of Optuna's JournalStorage, so that Optuna's tools can monitor the sweep")
+	fs.Int64Var(&cfg.MinFreeDisk, "min-free-disk", 0,
+		"stop starting trials and abort after the running ones when the free disk space of ./data/optuna falls below this size in bytes (0 to disable)")
+	fs.StringVar(&cfg.GroupFile, "group-file", "",
+		"file of the group of each validation example, e.g. the query or the user, a line per example, for precision_at_k and recall_at_k")
+	fs.IntVar(&cfg.RankK, "rank-k", 10, "K of precision_at_k and recall_at_k")
+	fs.IntVar(&cfg.DivergenceRetry, "divergence-retry", 0,
+		"prune a diverged trial and retry its params with the halved eta as a new trial, up to this many times in a row")
+	fs.DurationVar(&cfg.TrialTimeout, "trial-timeout", 0, "stop the runs of a trial after this duration (0 for no timeout)")
+	fs.StringVar(&cfg.TimeoutAction, "timeout-action", timeoutActionFail,
+		"fail a trial over -trial-timeout, or prune it with the best va_logloss of ffm-train before the timeout (fail|prune)")
+	fs.BoolVar(&cfg.Baseline, "baseline", false,
+		"evaluate the default params of ffm-train (lambda=0.00002, eta=0.2, latent=4) first and report the improvement of the best trial over them")
+	fs.StringVar(&cfg.ArtifactStore, "artifact-store", "",
+		"also store the meta, the model, the predictions and the output of each run to s3://bucket/prefix, signed by the AWS_* credentials (AWS_ENDPOINT_URL for an S3-compatible server)")
+	fs.IntVar(&cfg.ParamsFromTrial, "params-from-trial", -1,
+		"re-run the params of the trial of this number of the study without the storage, print it, log the recorded and the new values and exit")
+	fs.StringVar(&cfg.Serve, "serve", "",
+		"serve the HTTP API to start (POST /sweeps with the JSON of the config), poll (GET /sweeps/<id>) and cancel (DELETE /sweeps/<id>) sweeps on this address, like 127.0.0.1:8080; the API isn't authenticated")
+	fs.IntVar(&cfg.SplitFolds, "split-folds", 0,
+		"write this many train/valid folds of the training data to -fold-dir, shuffled by -data-seed, print their paths and exit")
+	fs.StringVar(&cfg.FoldDir, "fold-dir", "./data/folds",
+		"directory of the folds of -split-folds")
+	fs.StringVar(&cfg.TieBreak, "tie-break", tieBreakFirst,
+		"select the best of the trials tied at the best value by the earliest trial (first), the smallest latent (latent) or the smallest best_iteration (iteration), or report the ties and fail the sweep (fail)")
+	fs.Int64Var(&cfg.DataSeed, "data-seed", 0,
+		"seed of the splits of -random-split and -split-folds and of -gen-synthetic, independent of the sampler's (default: -seed)")
+	fs.IntVar(&cfg.MetricWorkers, "metric-workers", 1,
+		"number of the workers computing the metrics of the predictions in parallel, for a large validation data")
+	fs.BoolVar(&cfg.AnnotateTrials, "annotate-trials", false,
+		"add the user attrs which can be derived from the params and the values by the flags of the sweep to the finished trials lacking them, log the ones which can't and exit")
+	fs.IntVar(&cfg.MaxProcs, "max-procs", 0,
+		"run at most this many ffm-train processes at once, independently of -concurrency, so that the cached trials don't wait for the trainings (0 for no limit)")
+	fs.BoolVar(&cfg.WarmCache, "warm-cache", false,
+		"read the data once before the trials to warm the page cache, so that the first trials aren't slower than the rest")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		cfg.dataSeedSet = cfg.dataSeedSet || f.Name == "data-seed"
+	})
+	if !cfg.dataSeedSet {
+		cfg.DataSeed = cfg.Seed
+	}
+	return cfg, nil
+}
+
+// completeConfig validates the config and fills the fields derived from the
+// others, like RunID.
+func completeConfig(cfg *Config) error {
+	if cfg.ParetoJSON != "" {
+		cfg.MultiObjective = true
+	}
+	if cfg.LeaderElection && cfg.RunID == "" {
+		return errors.New("-leader-election requires -run-id shared by the workers")
+	}
+	if cfg.LeaderElection && dbLockPath(cfg.DSN) == "" {
+		return errors.New("-leader-election requires the workers to share the storage, which an in-memory -dsn isn't")
+	}
+	if cfg.RunID == "" {
+		id, err := newRunID()
+		if err != nil {
+			return fmt.Errorf("failed to generate a run ID: %s", err)
+		}
+		cfg.RunID = id
+	}
+	for _, tmpl := range []string{cfg.AutosavePath, cfg.FinalModel} {
+		if err := validateModelPath(tmpl); err != nil {
+			return err
+		}
+	}
+	if cfg.Repeats < 1 {
+		return errors.New("-repeats must be positive")
+	}
+	if cfg.Repeats > 1 && cfg.TrainSeedFlag == "" {
+		return errors.New("-repeats requires -train-seed-flag to vary the seeds")
+	}
+	if cfg.AutosaveEvery < 0 {
+		return errors.New("-autosave-every must not be negative")
+	}
+	if cfg.AutoScale && cfg.LatentBuckets != "" {
+		return errors.New("-auto-scale and -latent-buckets are exclusive")
+	}
+	if cfg.AUCExactLimit < 0 {
+		return errors.New("-auc-exact-limit must not be negative")
+	}
+	if cfg.ParamPrecision < 1 || cfg.ParamPrecision > 17 {
+		return errors.New("-param-precision must be between 1 and 17")
+	}
+	if cfg.Backup != "" && cfg.BackupInterval <= 0 {
+		return errors.New("-backup-interval must be positive")
+	}
+	if cfg.SamplerWindow != 0 && cfg.SamplerWindow < tpeStartupTrials {
+		return fmt.Errorf("-sampler-window must be 0 or at least %d", tpeStartupTrials)
+	}
+	if wrapper := strings.Fields(cfg.Wrapper); len(wrapper) > 0 {
+		if _, err := exec.LookPath(wrapper[0]); err != nil {
+			return fmt.Errorf("-wrapper %q is not found: %s", wrapper[0], err)
+		}
+	}
+	switch cfg.SearchSpaceCheck {
+	case searchSpaceCheckOff, searchSpaceCheckWarn, searchSpaceCheckError:
+	default:
+		return fmt.Errorf("-search-space-check must be %s, %s or %s",
+			searchSpaceCheckWarn, searchSpaceCheckError, searchSpaceCheckOff)
+	}
+	if cfg.EtaDecayFlag != "" && !(0 < cfg.EtaDecayMin && cfg.EtaDecayMin < cfg.EtaDecayMax && cfg.EtaDecayMax <= 1) {
+		return errors.New("-eta-decay-min and -eta-decay-max must satisfy 0 < min < max <= 1")
+	}
+	switch cfg.DBLock {
+	case dbLockOff, dbLockWait, dbLockError:
+	default:
+		return fmt.Errorf("-db-lock must be %s, %s or %s", dbLockError, dbLockWait, dbLockOff)
+	}
+	if len(cfg.OKExitCodes) == 0 {
+		return errors.New("-ok-exit-codes must not be empty")
+	}
+	if cfg.CSVAppend && cfg.CSV == "" {
+		return errors.New("-csv-append requires -csv")
+	}
+	if cfg.PredictBin == "" {
+		cfg.PredictBin = siblingPath(cfg.TrainBin, "ffm-predict")
+	}
+	if err := validateMetric(cfg.Metric); err != nil {
+		return err
+	}
+	if cfg.Normalize && cfg.Metric != metricVALoss && cfg.Metric != metricLogLoss {
+		return errors.New("-normalize supports only logloss metrics")
+	}
+	for _, m := range cfg.RecordMetrics {
+		if m == metricVALoss {
+			return errors.New("-record-metrics: va_loss is always recorded")
+		}
+		if err := validateMetric(m); err != nil {
+			return fmt.Errorf("-record-metrics: %s", err)
+		}
+		if metricIsRegression(m) != metricIsRegression(cfg.Metric) {
+			return fmt.Errorf("-record-metrics: %s doesn't fit the labels of metric %q", m, cfg.Metric)
+		}
+	}
+	needsGroups := metricNeedsGroups(cfg.Metric)
+	for _, m := range cfg.RecordMetrics {
+		needsGroups = needsGroups || metricNeedsGroups(m)
+	}
+	if needsGroups && cfg.GroupFile == "" {
+		return errors.New("precision_at_k and recall_at_k require -group-file")
+	}
+	if cfg.RankK < 1 {
+		return errors.New("-rank-k must be positive")
+	}
+	if len(cfg.RecordMetrics) > 0 && !metricNeedsPrediction(cfg.Metric) {
+		return errors.New("-record-metrics requires a -metric computed by ffm-predict")
+	}
+	if (cfg.PlausibleMin != 0 || cfg.PlausibleMax != 0) && cfg.PlausibleMin >= cfg.PlausibleMax {
+		return errors.New("-plausible-min must be less than -plausible-max")
+	}
+	if cfg.SelectFields && cfg.PrecomputeBin {
+		return errors.New("-select-fields trains on copies of the data, which -precompute-bin doesn't convert")
+	}
+	if cfg.MinTrialsForBest < 0 {
+		return errors.New("-min-trials-for-best must not be negative")
+	}
+	if cfg.ReevalTopK < 0 {
+		return errors.New("-reeval-top-k must not be negative")
+	}
+	if cfg.ReevalTopK > 0 && cfg.ReevalRepeats < 1 {
+		return errors.New("-reeval-repeats must be at least 1")
+	}
+	if cfg.ReevalTopK > 0 && cfg.TrainSeedFlag == "" {
+		return errors.New("-reeval-top-k requires -train-seed-flag to re-run with new seeds")
+	}
+	if cfg.PinCores != "" && cfg.WarmPool {
+		return errors.New("-pin-cores doesn't pin the trials of -warm-pool")
+	}
+	if cfg.Artifact != "" && cfg.FinalModel == "" {
+		return errors.New("-artifact requires -final-model to retrain the model to bundle")
+	}
+	if cfg.RandomSplit < 0 || cfg.RandomSplit >= 1 {
+		return errors.New("-random-split must be between 0 and 1")
+	}
+	if cfg.RandomSplit > 0 {
+		for _, f := range []struct {
+			name string
+			set  bool
+		}{
+			{"-cache-dir", cfg.CacheDir != ""},
+			{"-check-leakage", cfg.CheckLeakage},
+			{"-group-file", cfg.GroupFile != ""},
+			{"-precompute-bin", cfg.PrecomputeBin},
+			{"-reeval-top-k", cfg.ReevalTopK > 0},
+			{"-select-fields", cfg.SelectFields},
+		} {
+			if f.set {
+				return fmt.Errorf("-random-split splits the data per trial, which %s doesn't support", f.name)
+			}
+		}
+	}
+	switch cfg.Sampler {
+	case samplerTPE:
+		if cfg.Grid != "" {
+			return errors.New("-grid requires -sampler grid")
+		}
+	case samplerGrid:
+		if cfg.Grid == "" {
+			return errors.New("-sampler grid requires -grid")
+		}
+		if cfg.WarmStart != "" || cfg.LatentBuckets != "" {
+			return errors.New("-sampler grid supports neither -warm-start nor -latent-buckets")
+		}
+	default:
+		return fmt.Errorf("-sampler must be %s or %s", samplerTPE, samplerGrid)
+	}
+	if len(cfg.OnceParams) > 0 && !cfg.Once {
+		return errors.New("-param requires -once")
+	}
+	if cfg.Once && cfg.LatentBuckets != "" {
+		return errors.New("-once doesn't support -latent-buckets")
+	}
+	if cfg.MaxArtifactBytes < 0 {
+		return errors.New("-max-artifact-bytes must not be negative")
+	}
+	if cfg.SyntheticExamples < 1 || cfg.SyntheticFields < 1 || cfg.SyntheticFeatures < 1 {
+		return errors.New("-synthetic-examples, -synthetic-fields and -synthetic-features must be positive")
+	}
+	if cfg.MinFreeDisk < 0 {
+		return errors.New("-min-free-disk must not be negative")
+	}
+	if cfg.DivergenceRetry < 0 {
+		return errors.New("-divergence-retry must not be negative")
+	}
+	if cfg.DivergenceRetry > 0 && cfg.LatentBuckets != "" {
+		return errors.New("-divergence-retry doesn't support the eta of -latent-buckets")
+	}
+	if cfg.TrialTimeout < 0 {
+		return errors.New("-trial-timeout must not be negative")
+	}
+	switch cfg.TimeoutAction {
+	case timeoutActionFail:
+	case timeoutActionPrune:
+		if cfg.Metric != metricVALoss {
+			return errors.New("-timeout-action prune requires -metric va_loss, which ffm-train prints per iteration")
+		}
+	default:
+		return fmt.Errorf("-timeout-action must be %s or %s", timeoutActionFail, timeoutActionPrune)
+	}
+	if cfg.ParamsFromTrial < -1 {
+		return errors.New("-params-from-trial must be a trial number, or -1 to sweep")
+	}
+	if cfg.AnnotateTrials && cfg.LatentBuckets != "" {
+		return errors.New("-annotate-trials doesn't support -latent-buckets")
+	}
+	if cfg.ParamsFromTrial >= 0 && (cfg.Once || cfg.LatentBuckets != "") {
+		return errors.New("-params-from-trial supports neither -once nor -latent-buckets")
+	}
+	if cfg.SplitFolds < 0 || cfg.SplitFolds == 1 {
+		return errors.New("-split-folds must be at least 2")
+	}
+	switch cfg.TieBreak {
+	case tieBreakFirst, tieBreakLatent, tieBreakIteration, tieBreakFail:
+	default:
+		return fmt.Errorf("-tie-break must be %s, %s, %s or %s", tieBreakFirst, tieBreakLatent, tieBreakIteration, tieBreakFail)
+	}
+	if cfg.MetricWorkers < 1 {
+		return errors.New("-metric-workers must be positive")
+	}
+	if cfg.MaxProcs < 0 {
+		return errors.New("-max-procs must not be negative")
+	}
+	if cfg.MaxProcs > 0 && cfg.WarmPool {
+		return errors.New("-max-procs doesn't limit the trials of -warm-pool, which share one process")
+	}
+	if cfg.MaxLoad < 0 {
+		return errors.New("-max-load must not be negative")
+	}
+	if cfg.ECEBins < 1 {
+		return errors.New("-ece-bins must be positive")
+	}
+	if metricNeedsPrediction(cfg.Metric) {
+		if _, err := os.Stat(cfg.PredictBin); err != nil {
+			return fmt.Errorf("metric %q requires ffm-predict: %s", cfg.Metric, err)
+		}
+	}
+	return nil
+}
+
+// siblingPath returns the path of the named file in the directory of path.
+// It keeps a "./" prefix so that exec doesn't look the file up in PATH.
+func siblingPath(path, name string) string {
+	p := filepath.Join(filepath.Dir(path), name)
+	if !strings.ContainsRune(p, filepath.Separator) {
+		p = "." + string(filepath.Separator) + p
+	}
+	return p
+}
+
+// defaultConcurrency leaves a CPU for the database and the OS, but runs a
+// worker at least on a single-CPU machine.
+func defaultConcurrency() int {
+	if n := runtime.NumCPU() - 1; n > 1 {
+		return n
+	}
+	return 1
+}
+
+// applyEnv overrides the settings with the environment variables.
+func applyEnv(cfg *Config) error {
+	if v, ok := os.LookupEnv("FFM_TRAIN_PATH"); ok {
+		cfg.TrainBin = v
+	}
+	if v, ok := os.LookupEnv("FFM_DB_DSN"); ok {
+		cfg.DSN = v
+	}
+	if v, ok := os.LookupEnv("FFM_STUDY"); ok {
+		cfg.StudyName = v
+	}
+	if v, ok := os.LookupEnv("FFM_N_TRIALS"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid FFM_N_TRIALS: %s", err)
+		}
+		cfg.NTrials = n
+	}
+	if v, ok := os.LookupEnv("FFM_CONCURRENCY"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid FFM_CONCURRENCY: %s", err)
+		}
+		cfg.Concurrency = n
+	}
+	return nil
+}
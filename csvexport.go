@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/c-bata/goptuna"
+)
+
+// csvParamPrefix is a prefix of the columns holding params.
+const csvParamPrefix = "param_"
+
+var csvFixedColumns = []string{"number", "state", "value", "datetime_start", "datetime_complete", "run_id"}
+
+// exportCSV writes the finished trials to path. With appendOnly, the rows of
+// the trials which have a larger number than any row of the existing file
+// are appended, following the columns of the existing header.
+func exportCSV(path string, trials []goptuna.FrozenTrial, appendOnly bool) error {
+	sort.Slice(trials, func(i, j int) bool {
+		return trials[i].Number < trials[j].Number
+	})
+
+	columns := csvColumns(trials)
+	lastNumber := -1
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	writeHeader := true
+	if appendOnly {
+		header, last, err := readCSVState(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil {
+			columns, lastNumber = header, last
+			flag = os.O_WRONLY | os.O_APPEND
+			writeHeader = false
+		}
+	}
+
+	f, err := os.OpenFile(path, flag, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if writeHeader {
+		if err = w.Write(columns); err != nil {
+			return err
+		}
+	}
+	for _, t := range trials {
+		if t.Number <= lastNumber {
+			continue
+		}
+		// stop at a running trial so that it's exported by a later run,
+		// because rows are tracked by the largest exported number.
+		if !t.State.IsFinished() {
+			break
+		}
+		if err = w.Write(csvRow(t, columns)); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func csvColumns(trials []goptuna.FrozenTrial) []string {
+	names := make(map[string]struct{}, 8)
+	for _, t := range trials {
+		for k := range t.Params {
+			names[k] = struct{}{}
+		}
+	}
+	params := make([]string, 0, len(names))
+	for k := range names {
+		params = append(params, csvParamPrefix+k)
+	}
+	sort.Strings(params)
+	return append(append([]string{}, csvFixedColumns...), params...)
+}
+
+func csvRow(t goptuna.FrozenTrial, columns []string) []string {
+	row := make([]string, len(columns))
+	for i, c := range columns {
+		switch c {
+		case "number":
+			row[i] = strconv.Itoa(t.Number)
+		case "state":
+			row[i] = t.State.String()
+		case "value":
+			if t.State == goptuna.TrialStateComplete {
+				row[i] = strconv.FormatFloat(t.Value, 'g', -1, 64)
+			}
+		case "datetime_start":
+			row[i] = formatTime(t.DatetimeStart)
+		case "datetime_complete":
+			row[i] = formatTime(t.DatetimeComplete)
+		case "run_id":
+			row[i] = t.SystemAttrs[runIDAttrKey]
+		default:
+			if len(c) > len(csvParamPrefix) && c[:len(csvParamPrefix)] == csvParamPrefix {
+				if v, ok := t.Params[c[len(csvParamPrefix):]]; ok {
+					row[i] = fmt.Sprintf("%v", v)
+				}
+			}
+		}
+	}
+	return row
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// readCSVState returns the header and the largest trial number of the CSV.
+func readCSVState(path string) ([]string, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, -1, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, -1, os.ErrNotExist
+	}
+	if err != nil {
+		return nil, -1, err
+	}
+	col := -1
+	for i := range header {
+		if header[i] == "number" {
+			col = i
+		}
+	}
+	if col < 0 {
+		return nil, -1, fmt.Errorf("%s has no number column", path)
+	}
+
+	last := -1
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, -1, err
+		}
+		n, err := strconv.Atoi(record[col])
+		if err != nil {
+			return nil, -1, fmt.Errorf("invalid trial number %q: %s", record[col], err)
+		}
+		if n > last {
+			last = n
+		}
+	}
+	return header, last, nil
+}
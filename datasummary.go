@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/c-bata/goptuna"
+)
+
+// dataSummary is the approximate shape of libffm data.
+type dataSummary struct {
+	Fields   int64
+	Features int64
+	Examples int64
+}
+
+// summarizeData scans the libffm data once and counts the examples and the
+// distinct fields and features. The distinct counts are estimated by
+// HyperLogLog to keep the memory bounded.
+func summarizeData(path string) (dataSummary, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return dataSummary{}, err
+	}
+	defer f.Close()
+
+	var fields, features hyperLogLog
+	var examples int64
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		tokens := bytes.Fields(scanner.Bytes())
+		if len(tokens) == 0 {
+			continue
+		}
+		examples++
+		// tokens[0] is the label, and the others are "field:feature:value".
+		for _, t := range tokens[1:] {
+			parts := bytes.SplitN(t, []byte(":"), 3)
+			if len(parts) != 3 {
+				continue
+			}
+			fields.Add(parts[0])
+			features.Add(parts[1])
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return dataSummary{}, err
+	}
+	return dataSummary{
+		Fields:   fields.Count(),
+		Features: features.Count(),
+		Examples: examples,
+	}, nil
+}
+
+// storeDataSummary stores the summary as system attrs of the study, and
+// warns if the study was started with data of a different shape.
+func storeDataSummary(study *goptuna.Study, summary dataSummary) error {
+	for _, attr := range []struct {
+		key   string
+		value int64
+	}{
+		{"data_fields", summary.Fields},
+		{"data_features", summary.Features},
+		{"data_examples", summary.Examples},
+	} {
+		value := strconv.FormatInt(attr.value, 10)
+		old, err := setStudySystemAttrIfMissing(study, attr.key, value)
+		if err != nil {
+			return err
+		}
+		if old != "" && old != value {
+			log.Printf("%s differs from the stored value: %s (stored %s)", attr.key, value, old)
+		}
+	}
+	return nil
+}
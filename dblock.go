@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+// The modes of -db-lock.
+const (
+	dbLockOff   = "off"
+	dbLockWait  = "wait"
+	dbLockError = "error"
+)
+
+var errLocked = errors.New("locked by another process")
+
+// dbLockPath returns the path of the lock file of the SQLite database, or ""
+// for an in-memory database. The database file itself isn't locked because
+// SQLite takes its own locks on it.
+func dbLockPath(dsn string) string {
+	path := strings.TrimPrefix(dsn, "file:")
+	if i := strings.Index(path, "?"); i >= 0 {
+		path = path[:i]
+	}
+	if path == "" || path == ":memory:" {
+		return ""
+	}
+	return path + ".lock"
+}
+
+// dbLock is an advisory lock held by the process sweeping a study on the
+// database, so that a second process started by mistake doesn't write to
+// it concurrently. Running several workers on purpose requires -db-lock=off.
+type dbLock struct {
+	f *os.File
+}
+
+// lockDB takes the lock of the database. With wait, it blocks until the other
+// process releases it; otherwise it fails immediately.
+func lockDB(dsn string, wait bool) (*dbLock, error) {
+	path := dbLockPath(dsn)
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err = flock(f, wait); err == errLocked {
+		owner, _ := ioutil.ReadFile(path)
+		f.Close()
+		return nil, fmt.Errorf("%s is used by another process (%s); wait with -db-lock=wait or share it by -db-lock=off",
+			dsn, strings.TrimSpace(string(owner)))
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if wait {
+		log.Printf("locked %s", path)
+	}
+	// the owner is only for the error message of the other process.
+	if err = f.Truncate(0); err == nil {
+		_, err = f.WriteAt([]byte(fmt.Sprintf("pid=%d\n", os.Getpid())), 0)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &dbLock{f: f}, nil
+}
+
+// Close releases the lock. The lock file is left because removing it would
+// race with a process opening it.
+func (l *dbLock) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.f.Close()
+}
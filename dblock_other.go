@@ -0,0 +1,11 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package main
+
+import "os"
+
+// flock is not supported on this platform, so the database isn't locked.
+func flock(f *os.File, wait bool) error {
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// diskCheckInterval is the interval of checking the free disk space for
+// -min-free-disk.
+const diskCheckInterval = 10 * time.Second
+
+// diskMonitor stops the sweep when the free space of the disk of the files
+// of the trials falls below -min-free-disk, before ffm-train truncates a
+// model or a meta on a full disk. The workers stop starting new trials once
+// it's low, and the running trials finish.
+type diskMonitor struct {
+	path     string
+	minBytes int64
+
+	mu   sync.Mutex
+	free int64
+	low  bool
+}
+
+func newDiskMonitor(ctx context.Context, path string, minBytes int64) (*diskMonitor, error) {
+	m := &diskMonitor{path: path, minBytes: minBytes}
+	if err := m.check(); err != nil {
+		return nil, err
+	}
+	go m.monitor(ctx)
+	return m, nil
+}
+
+func (m *diskMonitor) monitor(ctx context.Context) {
+	ticker := time.NewTicker(diskCheckInterval)
+	defer ticker.Stop()
+	for !m.Low() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := m.check(); err != nil {
+			log.Print("failed to check the free disk space:", err)
+		}
+	}
+}
+
+func (m *diskMonitor) check() error {
+	free, err := freeDiskBytes(m.path)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.free = free
+	if free < m.minBytes && !m.low {
+		m.low = true
+		log.Printf("free disk space of %s is %d bytes, below -min-free-disk=%d: "+
+			"stopping the sweep after the running trials", m.path, free, m.minBytes)
+	}
+	return nil
+}
+
+// Low reports whether the free disk space has fallen below the threshold.
+func (m *diskMonitor) Low() bool {
+	if m == nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.low
+}
+
+// Err returns the error to abort the sweep with if the disk is low.
+func (m *diskMonitor) Err() error {
+	if !m.Low() {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return fmt.Errorf("aborted on %d bytes of free disk space, below -min-free-disk=%d", m.free, m.minBytes)
+}
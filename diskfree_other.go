@@ -0,0 +1,11 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux
+// +build !darwin,!dragonfly,!freebsd,!linux
+
+package main
+
+import "errors"
+
+// freeDiskBytes is not supported on this platform.
+func freeDiskBytes(path string) (int64, error) {
+	return 0, errors.New("free disk space is not available on this platform")
+}
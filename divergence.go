@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/c-bata/goptuna"
+)
+
+// errDiverged is returned when the loss of ffm-train is NaN or infinite.
+var errDiverged = errors.New("ffm-train diverged")
+
+// divergedAttrKey is a user attr of the trials whose training diverged.
+const divergedAttrKey = "diverged"
+
+// divergenceRetriesAttrKey is a system attr of the trials of -divergence-retry
+// of the number of the retries in a row, and divergenceRetryOfAttrKey is the
+// one of the number of the diverged trial which it retries.
+const (
+	divergenceRetriesAttrKey = "divergence_retries"
+	divergenceRetryOfAttrKey = "divergence_retry_of"
+)
+
+// nonFiniteJSON matches the non-finite numbers which libffm prints as is,
+// making the JSON meta invalid.
+var nonFiniteJSON = regexp.MustCompile(`(?i)(:\s*)([-+]?(?:nan|inf(?:inity)?))\b`)
+
+// parseJSONMeta parses the JSON meta written by ffm-train. A NaN or infinite
+// loss is parsed as is instead of an error, so that divergence is told from
+// a broken file.
+func parseJSONMeta(b []byte) (int, float64, error) {
+	var result struct {
+		BestIteration int         `json:"best_iteration"`
+		BestVALoss    interface{} `json:"best_va_loss"`
+	}
+	b = nonFiniteJSON.ReplaceAll(b, []byte(`$1"$2"`))
+	if err := json.Unmarshal(b, &result); err != nil {
+		return 0, 0, err
+	}
+	switch v := result.BestVALoss.(type) {
+	case float64:
+		return result.BestIteration, v, nil
+	case string:
+		// printf of C writes "-nan", which ParseFloat doesn't accept.
+		if strings.EqualFold(strings.TrimLeft(v, "+-"), "nan") {
+			return result.BestIteration, math.NaN(), nil
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid best_va_loss %q", v)
+		}
+		return result.BestIteration, f, nil
+	case nil:
+		return result.BestIteration, 0, nil
+	}
+	return 0, 0, fmt.Errorf("invalid best_va_loss %v", result.BestVALoss)
+}
+
+func isDiverged(v float64) bool {
+	return math.IsNaN(v) || math.IsInf(v, 0)
+}
+
+// divergedValue is the objective value of a diverged trial with
+// -penalize-divergence. It is worse than any finite loss, so that TPE puts
+// its params into the bad group. An infinite value isn't stored by RDBs.
+func divergedValue(direction goptuna.StudyDirection) float64 {
+	if direction == goptuna.StudyDirectionMaximize {
+		return -1e30
+	}
+	return 1e30
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// evalCache stores the evaluations of ffm-train runs in a directory, one JSON
+// file per run named by the hash of everything which determines the result:
+// the hyperparameter arguments, the seed, the metric, and the path, size and
+// modification time of the data, the groups of -group-file and the binaries.
+// Changing the data or rebuilding libffm changes the hash, so stale results
+// are never read.
+type evalCache struct {
+	dir string
+	// base is the part of the keys shared by the runs.
+	base string
+}
+
+// cachedEvaluation is the content of a cache file.
+type cachedEvaluation struct {
+	BestIteration int     `json:"best_iteration"`
+	VALoss        float64 `json:"va_loss"`
+	Value         float64 `json:"value"`
+	// Extra is the metrics of Config.RecordMetrics.
+	Extra map[string]float64 `json:"extra,omitempty"`
+}
+
+func newEvalCache(cfg *Config) (*evalCache, error) {
+	if err := os.MkdirAll(cfg.CacheDir, 0755); err != nil {
+		return nil, err
+	}
+	files := []string{cfg.TrainPath, cfg.ValidPath}
+	if cfg.GroupFile != "" {
+		files = append(files, cfg.GroupFile)
+	}
+	data := len(files)
+	files = append(files, cfg.TrainBin)
+	if metricNeedsPrediction(cfg.Metric) {
+		files = append(files, cfg.PredictBin)
+	}
+	parts := []string{
+		cfg.Metric,
+		strconv.Itoa(cfg.AUCExactLimit),
+		strings.Join(cfg.RecordMetrics, ","),
+		strconv.Itoa(cfg.ECEBins),
+		strconv.Itoa(cfg.RankK),
+	}
+	for i, f := range files {
+		if i >= data {
+			// the binaries may be looked up in PATH.
+			if p, err := exec.LookPath(f); err == nil {
+				f = p
+			}
+		}
+		fp, err := fileFingerprint(f)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, fp)
+	}
+	return &evalCache{dir: cfg.CacheDir, base: strings.Join(parts, "\n")}, nil
+}
+
+func fileFingerprint(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %d %d", abs, fi.Size(), fi.ModTime().UnixNano()), nil
+}
+
+// Key returns the key of a run with the hyperparameter and seed arguments.
+func (c *evalCache) Key(args []string) string {
+	h := sha256.New()
+	h.Write([]byte(c.base))
+	for _, a := range args {
+		h.Write([]byte("\n" + a))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *evalCache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+// Get returns the cached evaluation of the key, if any.
+func (c *evalCache) Get(key string) (evaluation, bool) {
+	b, err := ioutil.ReadFile(c.path(key))
+	if err != nil {
+		return evaluation{}, false
+	}
+	var e cachedEvaluation
+	if err = json.Unmarshal(b, &e); err != nil {
+		return evaluation{}, false
+	}
+	return evaluation{
+		bestIteration: e.BestIteration,
+		vaLoss:        e.VALoss,
+		value:         e.Value,
+		cached:        true,
+		extra:         e.Extra,
+	}, true
+}
+
+// Put stores the evaluation. The file is renamed into place, so concurrent
+// readers never see a partial file.
+func (c *evalCache) Put(key string, e evaluation) error {
+	b, err := json.Marshal(cachedEvaluation{
+		BestIteration: e.bestIteration,
+		VALoss:        e.vaLoss,
+		Value:         e.value,
+		Extra:         e.extra,
+	})
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(c.dir, key+".tmp*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path(key))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/c-bata/goptuna"
+)
+
+// eventClientBuffer is the number of the events buffered for a client of
+// -event-socket. A client which falls further behind is disconnected rather
+// than blocking the trials.
+const eventClientBuffer = 64
+
+// eventServer pushes the finished trials to the clients of a Unix domain
+// socket as JSON lines in the format of -export. The clients receive the
+// trials finished after they connect.
+type eventServer struct {
+	listener net.Listener
+	trials   chan goptuna.FrozenTrial
+	done     chan struct{}
+
+	mu      sync.Mutex
+	clients map[net.Conn]chan []byte
+}
+
+// newEventServer listens on the socket at path. A stale socket left by a
+// killed process is removed, but no other kind of file is.
+func newEventServer(path string) (*eventServer, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is used by another process", path)
+		}
+		if err = os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	s := &eventServer{
+		listener: listener,
+		trials:   make(chan goptuna.FrozenTrial, eventClientBuffer),
+		done:     make(chan struct{}),
+		clients:  make(map[net.Conn]chan []byte, 4),
+	}
+	go s.acceptLoop()
+	go s.broadcastLoop()
+	return s, nil
+}
+
+// Trials returns the channel to notify the finished trials to, which is
+// passed to goptuna.StudyOptionSetTrialNotifyChannel.
+func (s *eventServer) Trials() chan goptuna.FrozenTrial {
+	return s.trials
+}
+
+func (s *eventServer) acceptLoop() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		ch := make(chan []byte, eventClientBuffer)
+		s.mu.Lock()
+		s.clients[conn] = ch
+		s.mu.Unlock()
+		go s.writeLoop(conn, ch)
+	}
+}
+
+func (s *eventServer) writeLoop(conn net.Conn, ch chan []byte) {
+	defer conn.Close()
+	for line := range ch {
+		if _, err := conn.Write(line); err != nil {
+			s.drop(conn)
+			return
+		}
+	}
+}
+
+// drop disconnects the client. Its channel is closed once, by whichever of
+// the writer and the broadcaster drops it first.
+func (s *eventServer) drop(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ch, ok := s.clients[conn]; ok {
+		delete(s.clients, conn)
+		close(ch)
+	}
+}
+
+func (s *eventServer) broadcastLoop() {
+	defer close(s.done)
+	for t := range s.trials {
+		line, err := json.Marshal(newExportedTrial(t, exportAttrsOmitted))
+		if err != nil {
+			log.Print("failed to encode the trial event:", err)
+			continue
+		}
+		line = append(line, '\n')
+		s.mu.Lock()
+		for conn, ch := range s.clients {
+			select {
+			case ch <- line:
+			default:
+				log.Print("disconnected a slow client of the event socket")
+				delete(s.clients, conn)
+				close(ch)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Close sends the pending events, disconnects the clients and removes the
+// socket. It must be called after the study stops notifying trials.
+func (s *eventServer) Close() error {
+	close(s.trials)
+	<-s.done
+	err := s.listener.Close()
+	s.mu.Lock()
+	for conn, ch := range s.clients {
+		delete(s.clients, conn)
+		close(ch)
+	}
+	s.mu.Unlock()
+	return err
+}
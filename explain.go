@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/c-bata/goptuna"
+)
+
+// explainBins is the number of quantile bins of each param over which the
+// correlation ratio of -explain is computed.
+const explainBins = 5
+
+// paramImportance is the importance of a param estimated from the completed
+// trials.
+type paramImportance struct {
+	Name string
+	// Spearman is the rank correlation of the param and the value, which
+	// tells the direction of a monotonic effect.
+	Spearman float64
+	// Eta2 is the correlation ratio: the share of the variance of the value
+	// explained by the mean value of each quantile bin of the param. Unlike
+	// the correlation, it also catches an optimum in the middle of the range.
+	Eta2 float64
+}
+
+// explainStudy prints the params of the study ranked by their importance.
+// This is a rough approximation of fANOVA which looks at each param alone, so
+// interactions like that of lambda and eta are not separated, and the
+// estimate is biased toward the region where the sampler concentrated.
+func explainStudy(cfg *Config) error {
+	study, db, err := loadExistingStudy(cfg.DSN, cfg.StudyName)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	importances, n, err := paramImportances(study)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "param\teta2\tspearman\n")
+	for _, imp := range importances {
+		fmt.Fprintf(w, "%s\t%.3f\t%+.3f\n", imp.Name, imp.Eta2, imp.Spearman)
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("from %d completed trials\n", n)
+	return nil
+}
+
+// paramImportances returns the importances of the numeric params sorted by
+// Eta2 in descending order, and the number of trials they are computed from.
+func paramImportances(study *goptuna.Study) ([]paramImportance, int, error) {
+	trials, err := study.GetTrials()
+	if err != nil {
+		return nil, 0, err
+	}
+	var values []float64
+	columns := make(map[string][]float64, 4)
+	for _, t := range trials {
+		if t.State != goptuna.TrialStateComplete || t.UserAttrs[divergedAttrKey] != "" || isDiverged(t.Value) {
+			continue
+		}
+		params, err := trialParams(t)
+		if err != nil {
+			return nil, 0, err
+		}
+		for name, v := range params {
+			x, ok := numericParam(v)
+			if !ok {
+				continue
+			}
+			// the trials lacking a param are filled with NaN and skipped
+			// when computing its importance.
+			col := columns[name]
+			for len(col) < len(values) {
+				col = append(col, math.NaN())
+			}
+			columns[name] = append(col, x)
+		}
+		values = append(values, t.Value)
+	}
+	if len(values) < explainBins {
+		return nil, len(values), fmt.Errorf("%d completed trials are too few to explain", len(values))
+	}
+
+	importances := make([]paramImportance, 0, len(columns))
+	for name, col := range columns {
+		var xs, ys []float64
+		for i, x := range col {
+			if !math.IsNaN(x) {
+				xs = append(xs, x)
+				ys = append(ys, values[i])
+			}
+		}
+		if len(xs) < explainBins || isConstant(xs) {
+			continue
+		}
+		importances = append(importances, paramImportance{
+			Name:     name,
+			Spearman: spearman(xs, ys),
+			Eta2:     correlationRatio(xs, ys, explainBins),
+		})
+	}
+	if len(importances) == 0 {
+		return nil, len(values), errors.New("no param varies across the completed trials")
+	}
+	sort.Slice(importances, func(i, j int) bool {
+		if importances[i].Eta2 != importances[j].Eta2 {
+			return importances[i].Eta2 > importances[j].Eta2
+		}
+		return importances[i].Name < importances[j].Name
+	})
+	return importances, len(values), nil
+}
+
+// numericParam converts a param to a number, including the integer strings
+// of a categorical latent.
+func numericParam(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case string:
+		x, err := strconv.ParseFloat(v, 64)
+		return x, err == nil
+	default:
+		return 0, false
+	}
+}
+
+func isConstant(xs []float64) bool {
+	for _, x := range xs {
+		if x != xs[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// ranks returns the ranks of xs from 0, where ties get their average rank.
+func ranks(xs []float64) []float64 {
+	idx := make([]int, len(xs))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(i, j int) bool { return xs[idx[i]] < xs[idx[j]] })
+	r := make([]float64, len(xs))
+	for i := 0; i < len(idx); {
+		j := i + 1
+		for j < len(idx) && xs[idx[j]] == xs[idx[i]] {
+			j++
+		}
+		avg := float64(i+j-1) / 2
+		for k := i; k < j; k++ {
+			r[idx[k]] = avg
+		}
+		i = j
+	}
+	return r
+}
+
+// spearman returns the Pearson correlation of the ranks of xs and ys, or 0
+// if either is constant.
+func spearman(xs, ys []float64) float64 {
+	rx, ry := ranks(xs), ranks(ys)
+	n := float64(len(rx))
+	var mx, my float64
+	for i := range rx {
+		mx += rx[i]
+		my += ry[i]
+	}
+	mx /= n
+	my /= n
+	var sxy, sxx, syy float64
+	for i := range rx {
+		dx, dy := rx[i]-mx, ry[i]-my
+		sxy += dx * dy
+		sxx += dx * dx
+		syy += dy * dy
+	}
+	if sxx == 0 || syy == 0 {
+		return 0
+	}
+	return sxy / math.Sqrt(sxx*syy)
+}
+
+// correlationRatio returns the between-bin variance of ys over its total
+// variance, where the bins split the ranks of xs into equal counts. Equal
+// values of xs always fall into the same bin.
+func correlationRatio(xs, ys []float64, bins int) float64 {
+	rx := ranks(xs)
+	n := float64(len(ys))
+	var mean float64
+	for _, y := range ys {
+		mean += y
+	}
+	mean /= n
+
+	sums := make([]float64, bins)
+	counts := make([]float64, bins)
+	var total float64
+	for i, y := range ys {
+		b := int(rx[i] * float64(bins) / n)
+		sums[b] += y
+		counts[b]++
+		total += (y - mean) * (y - mean)
+	}
+	if total == 0 {
+		return 0
+	}
+	var between float64
+	for b := range sums {
+		if counts[b] > 0 {
+			d := sums[b]/counts[b] - mean
+			between += counts[b] * d * d
+		}
+	}
+	return between / total
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/c-bata/goptuna"
+)
+
+// exportAttrsOmitted are the user attrs which are too large for analytics.
+var exportAttrsOmitted = map[string]bool{"stdout": true, "stderr": true}
+
+// exportedTrial is a line of -export.
+type exportedTrial struct {
+	Number           int                    `json:"number"`
+	State            string                 `json:"state"`
+	Value            *float64               `json:"value"`
+	DatetimeStart    *time.Time             `json:"datetime_start"`
+	DatetimeComplete *time.Time             `json:"datetime_complete"`
+	Params           map[string]interface{} `json:"params"`
+	UserAttrs        map[string]string      `json:"user_attrs"`
+	SystemAttrs      map[string]string      `json:"system_attrs"`
+}
+
+// exportTrials writes all trials of the study to path as JSON lines, which
+// analytics tools read without depending on goptuna's schema.
+func exportTrials(cfg *Config) error {
+	study, db, err := loadExistingStudy(cfg.DSN, cfg.StudyName)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	trials, err := study.GetTrials()
+	if err != nil {
+		return err
+	}
+	sort.Slice(trials, func(i, j int) bool {
+		return trials[i].Number < trials[j].Number
+	})
+
+	f, err := os.Create(cfg.Export)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, t := range trials {
+		if err = enc.Encode(newExportedTrial(t, exportAttrsOmitted)); err != nil {
+			return err
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// newExportedTrial converts the trial, dropping the user attrs in omitted.
+func newExportedTrial(t goptuna.FrozenTrial, omitted map[string]bool) exportedTrial {
+	e := exportedTrial{
+		Number:      t.Number,
+		State:       t.State.String(),
+		Params:      t.Params,
+		UserAttrs:   make(map[string]string, len(t.UserAttrs)),
+		SystemAttrs: t.SystemAttrs,
+	}
+	if t.State == goptuna.TrialStateComplete {
+		v := t.Value
+		e.Value = &v
+	}
+	if !t.DatetimeStart.IsZero() {
+		e.DatetimeStart = &t.DatetimeStart
+	}
+	if !t.DatetimeComplete.IsZero() {
+		e.DatetimeComplete = &t.DatetimeComplete
+	}
+	for k, v := range t.UserAttrs {
+		if !omitted[k] {
+			e.UserAttrs[k] = v
+		}
+	}
+	return e
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The categories of the failed trials, which are counted at the end of a
+// study.
+const (
+	failureCanceled       = "canceled"
+	failureExec           = "exec"
+	failureExitCode       = "non_zero_exit"
+	failureServer         = "server"
+	failureMetaNotWritten = "meta_not_written"
+	failureMetaParse      = "meta_parse"
+	failurePredict        = "predict"
+	failureDiverged       = "diverged"
+	failureImplausible    = "implausible"
+	failureStorage        = "storage"
+	failureTimeout        = "timeout"
+	failureOther          = "other"
+)
+
+// trialError is an error of a trial with its category.
+type trialError struct {
+	category string
+	err      error
+}
+
+func (e *trialError) Error() string {
+	return e.err.Error()
+}
+
+func categorize(category string, err error) error {
+	return &trialError{category: category, err: err}
+}
+
+// errorCategory returns the category of an error returned by the objective.
+func errorCategory(err error) string {
+	if err == errDiverged {
+		return failureDiverged
+	}
+	if e, ok := err.(*trialError); ok {
+		return e.category
+	}
+	return failureOther
+}
+
+// failureCounter counts the failed trials by category.
+type failureCounter struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (c *failureCounter) Add(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[string]int, 4)
+	}
+	c.counts[errorCategory(err)]++
+}
+
+// String formats the counts like "30 non_zero_exit, 5 meta_parse", the most
+// frequent first, or "" if no trial failed.
+func (c *failureCounter) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	categories := make([]string, 0, len(c.counts))
+	for category := range c.counts {
+		categories = append(categories, category)
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		if c.counts[categories[i]] != c.counts[categories[j]] {
+			return c.counts[categories[i]] > c.counts[categories[j]]
+		}
+		return categories[i] < categories[j]
+	})
+	parts := make([]string, len(categories))
+	for i, category := range categories {
+		parts[i] = fmt.Sprintf("%d %s", c.counts[category], category)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// featureMap is the names of the field and feature indices of libffm data.
+type featureMap struct {
+	fields   map[int]string
+	features map[int]string
+}
+
+// loadFeatureMap reads a dictionary of the indices. Each line is
+// "field <index> <name>" or "feature <index> <name>", where the name is the
+// rest of the line. Empty lines and lines starting with "#" are skipped.
+func loadFeatureMap(path string) (*featureMap, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m := &featureMap{
+		fields:   make(map[int]string, 64),
+		features: make(map[int]string, 1024),
+	}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		parts := strings.SplitN(text, " ", 3)
+		if len(parts) != 3 || strings.TrimSpace(parts[2]) == "" {
+			return nil, fmt.Errorf("line %d of %s: expected \"<field|feature> <index> <name>\"", line, path)
+		}
+		idx, err := strconv.Atoi(parts[1])
+		if err != nil || idx < 0 {
+			return nil, fmt.Errorf("line %d of %s: invalid index %q", line, path, parts[1])
+		}
+		name := strings.TrimSpace(parts[2])
+		switch parts[0] {
+		case "field":
+			m.fields[idx] = name
+		case "feature":
+			m.features[idx] = name
+		default:
+			return nil, fmt.Errorf("line %d of %s: unknown kind %q", line, path, parts[0])
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Field returns the name of the field, or the index if it isn't named.
+func (m *featureMap) Field(idx int) string {
+	if m != nil {
+		if name, ok := m.fields[idx]; ok {
+			return name
+		}
+	}
+	return strconv.Itoa(idx)
+}
+
+// Feature returns the name of the feature, or the index if it isn't named.
+func (m *featureMap) Feature(idx int) string {
+	if m != nil {
+		if name, ok := m.features[idx]; ok {
+			return name
+		}
+	}
+	return strconv.Itoa(idx)
+}
+
+// FieldNames returns the names of the fields from 0 to n-1.
+func (m *featureMap) FieldNames(n int) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = m.Field(i)
+	}
+	return names
+}
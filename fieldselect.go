@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxSelectableFields is the largest number of fields of -select-fields.
+// Each field is a param, and TPE models the params independently, so the
+// search gets hopeless well before the 2^n masks are exhausted.
+const maxSelectableFields = 24
+
+// fieldParamPrefix is the prefix of the params of -select-fields, which are
+// "1" to train with the field and "0" to drop it, e.g. "field_3".
+const fieldParamPrefix = "field_"
+
+// fieldChoices are the values of the params of -select-fields. They are
+// categorical because goptuna's random sampler never samples the upper
+// bound of SuggestInt.
+var fieldChoices = []string{"0", "1"}
+
+func fieldParamName(field int) string {
+	return fieldParamPrefix + strconv.Itoa(field)
+}
+
+// errNoFields is returned by selectedData when no field is selected.
+var errNoFields = errors.New("no field is selected")
+
+// dataPaths are the training and validation data of a run.
+type dataPaths struct {
+	train string
+	valid string
+}
+
+// scanFields returns the sorted indices of the fields in the libffm data.
+func scanFields(path string) ([]int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	seen := make(map[int]bool, maxSelectableFields)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		tokens := bytes.Fields(scanner.Bytes())
+		if len(tokens) == 0 {
+			continue
+		}
+		for _, t := range tokens[1:] {
+			i := bytes.IndexByte(t, ':')
+			if i < 0 {
+				continue
+			}
+			field, err := strconv.Atoi(string(t[:i]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid field %q in %s", t[:i], path)
+			}
+			if !seen[field] {
+				if len(seen) == maxSelectableFields {
+					return nil, fmt.Errorf("%s has more than %d fields to select", path, maxSelectableFields)
+				}
+				seen[field] = true
+			}
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	fields := make([]int, 0, len(seen))
+	for field := range seen {
+		fields = append(fields, field)
+	}
+	sort.Ints(fields)
+	return fields, nil
+}
+
+// selectedFields returns the sorted fields whose params of -select-fields
+// are 1, and the number of the params of -select-fields.
+func selectedFields(params map[string]interface{}) ([]int, int, error) {
+	var fields []int
+	var found int
+	for name := range params {
+		if !strings.HasPrefix(name, fieldParamPrefix) {
+			continue
+		}
+		field, err := strconv.Atoi(strings.TrimPrefix(name, fieldParamPrefix))
+		if err != nil {
+			continue
+		}
+		found++
+		on, err := intParam(params, name)
+		if err != nil {
+			return nil, 0, err
+		}
+		if on == 1 {
+			fields = append(fields, field)
+		}
+	}
+	sort.Ints(fields)
+	return fields, found, nil
+}
+
+var (
+	filterMu    sync.Mutex
+	filterLocks = make(map[string]*sync.Mutex, 8)
+)
+
+// selectedData returns the data which the params train on: cfg.TrainPath and
+// cfg.ValidPath, or their copies with only the fields selected by
+// -select-fields. The copies are named by the fields under ./data/optuna and
+// are written once, so the trials with the same fields share them. Selecting
+// all fields trains on the original data.
+func selectedData(cfg *Config, params map[string]interface{}) (dataPaths, error) {
+	fields, found, err := selectedFields(params)
+	if err != nil || len(fields) == found {
+		return dataPaths{train: cfg.TrainPath, valid: cfg.ValidPath}, err
+	}
+	if len(fields) == 0 {
+		return dataPaths{}, errNoFields
+	}
+	names := make([]string, len(fields))
+	keep := make(map[string]bool, len(fields))
+	for i, field := range fields {
+		names[i] = strconv.Itoa(field)
+		keep[names[i]] = true
+	}
+	suffix := "-fields-" + strings.Join(names, "_")
+
+	// the same fields are filtered by one trial while the others wait.
+	filterMu.Lock()
+	lock, ok := filterLocks[suffix]
+	if !ok {
+		lock = &sync.Mutex{}
+		filterLocks[suffix] = lock
+	}
+	filterMu.Unlock()
+	lock.Lock()
+	defer lock.Unlock()
+
+	var paths [2]string
+	for i, src := range []string{cfg.TrainPath, cfg.ValidPath} {
+		ext := filepath.Ext(src)
+		base := strings.TrimSuffix(filepath.Base(src), ext)
+		paths[i] = filepath.Join("./data/optuna", base+suffix+ext)
+		if _, err = os.Stat(paths[i]); err == nil {
+			continue
+		}
+		if err = filterFields(src, paths[i], keep); err != nil {
+			return dataPaths{}, fmt.Errorf("failed to filter the fields of %s: %s", src, err)
+		}
+	}
+	return dataPaths{train: paths[0], valid: paths[1]}, nil
+}
+
+// filterFields copies the libffm data with the tokens of the fields in keep.
+// The lines are kept even if empty but the label, so the predictions stay
+// aligned with the labels of the validation data.
+func filterFields(src, dst string, keep map[string]bool) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err = os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), filepath.Base(dst)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		tokens := bytes.Fields(scanner.Bytes())
+		if len(tokens) == 0 {
+			continue
+		}
+		w.Write(tokens[0])
+		for _, t := range tokens[1:] {
+			i := bytes.IndexByte(t, ':')
+			if i < 0 || !keep[string(t[:i])] {
+				continue
+			}
+			w.WriteByte(' ')
+			w.Write(t)
+		}
+		w.WriteByte('\n')
+	}
+	if err = scanner.Err(); err == nil {
+		err = w.Flush()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// keyValueFlag is a repeatable flag which collects "key=value" pairs.
+type keyValueFlag map[string]string
+
+func (f keyValueFlag) String() string {
+	pairs := make([]string, 0, len(f))
+	for k, v := range f {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f keyValueFlag) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return fmt.Errorf("%q is not in key=value format", s)
+	}
+	key := s[:i]
+	if _, ok := f[key]; ok {
+		return fmt.Errorf("%q is specified more than once", key)
+	}
+	f[key] = s[i+1:]
+	return nil
+}
+
+// intSetFlag is a flag of comma-separated integers like "0,1". Setting it
+// replaces the default set.
+type intSetFlag map[int]bool
+
+func (f intSetFlag) String() string {
+	values := make([]int, 0, len(f))
+	for v := range f {
+		values = append(values, v)
+	}
+	sort.Ints(values)
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = strconv.Itoa(v)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f intSetFlag) Set(s string) error {
+	for v := range f {
+		delete(f, v)
+	}
+	for _, part := range strings.Split(s, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", part)
+		}
+		f[v] = true
+	}
+	return nil
+}
+
+// stringListFlag is a flag of comma-separated strings like "brier,ece".
+type stringListFlag []string
+
+func (f *stringListFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringListFlag) Set(s string) error {
+	*f = nil
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			*f = append(*f, part)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/c-bata/goptuna"
+)
+
+// The samplers of -sampler.
+const (
+	samplerTPE  = "tpe"
+	samplerGrid = "grid"
+)
+
+// maxGridPoints is the largest grid of -sampler grid, which is mostly a typo
+// of a value list rather than a sweep anyone waits for.
+const maxGridPoints = 100000
+
+// loadGrid reads the grid of -grid, a JSON object of the values by param
+// name like {"latent": [4, 8], "lambda": [1e-5, 1e-4], ...}, and returns the
+// params of every point of the grid. The grid must have the values of every
+// param in dists and nothing else, and the values must be in the
+// distributions.
+func loadGrid(path string, dists map[string]interface{}) ([]map[string]interface{}, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var grid map[string][]interface{}
+	if err = json.Unmarshal(b, &grid); err != nil {
+		return nil, fmt.Errorf("%s is not a JSON object of value arrays: %s", path, err)
+	}
+
+	names := make([]string, 0, len(dists))
+	for name := range dists {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for name := range grid {
+		if _, ok := dists[name]; !ok {
+			return nil, fmt.Errorf("unknown param %q in %s", name, path)
+		}
+	}
+	values := make([][]interface{}, len(names))
+	points := 1
+	for i, name := range names {
+		if _, ok := grid[name]; !ok {
+			return nil, fmt.Errorf("param %q is missing in %s", name, path)
+		}
+		if len(grid[name]) == 0 {
+			return nil, fmt.Errorf("param %q has no values in %s", name, path)
+		}
+		for _, xr := range grid[name] {
+			ir, err := toInternalRepr(dists[name], xr)
+			if err != nil {
+				return nil, fmt.Errorf("param %q in %s: %s", name, path, err)
+			}
+			v, err := goptuna.ToExternalRepresentation(dists[name], ir)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = append(values[i], v)
+		}
+		points *= len(values[i])
+		if points > maxGridPoints {
+			return nil, fmt.Errorf("%s has more than %d points", path, maxGridPoints)
+		}
+	}
+
+	// the last param varies fastest, like nested loops in the order of names.
+	params := make([]map[string]interface{}, points)
+	for p := range params {
+		params[p] = make(map[string]interface{}, len(names))
+		rest := p
+		for i := len(names) - 1; i >= 0; i-- {
+			params[p][names[i]] = values[i][rest%len(values[i])]
+			rest /= len(values[i])
+		}
+	}
+	return params, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/c-bata/goptuna"
+	"github.com/c-bata/goptuna/rdb"
+)
+
+// initDB creates the storage schema and the configured study with the
+// direction of the objective, then returns without running trials. It's
+// idempotent: an existing study is left as is if its direction matches, so
+// that it can provision a shared study before starting the workers.
+func initDB(cfg *Config) error {
+	db, err := openDB(cfg.DSN)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %s", err)
+	}
+	defer db.Close()
+	if cfg.CheckSchema {
+		if err = checkSchema(db); err != nil {
+			return fmt.Errorf("failed to check the storage schema: %s", err)
+		}
+	}
+	rdb.RunAutoMigrate(db)
+	if cfg.CheckSchema {
+		if err = stampSchema(db); err != nil {
+			return fmt.Errorf("failed to store the schema version: %s", err)
+		}
+	}
+	storage := rdb.NewStorage(db)
+
+	exists, err := studyExists(storage, cfg.StudyName)
+	if err != nil {
+		return fmt.Errorf("failed to look up study: %s", err)
+	}
+	direction := objectiveDirection(cfg)
+	opts := []goptuna.StudyOption{
+		goptuna.StudyOptionStorage(storage),
+		goptuna.StudyOptionLogger(nil),
+	}
+	var study *goptuna.Study
+	if exists {
+		if study, err = goptuna.LoadStudy(cfg.StudyName, opts...); err != nil {
+			return fmt.Errorf("failed to load study: %s", err)
+		}
+		if study.Direction() != direction {
+			return fmt.Errorf("study %q exists with direction %s, but -metric %s is to %s",
+				cfg.StudyName, study.Direction(), cfg.Metric, direction)
+		}
+		log.Printf("study %q already exists", cfg.StudyName)
+	} else {
+		opts = append(opts, goptuna.StudyOptionSetDirection(direction))
+		if study, err = goptuna.CreateStudy(cfg.StudyName, opts...); err != nil {
+			return fmt.Errorf("failed to create study: %s", err)
+		}
+		log.Printf("created study %q to %s %s", cfg.StudyName, direction, cfg.Metric)
+	}
+	if err = setLabels(study, cfg.Labels); err != nil {
+		return fmt.Errorf("failed to set labels: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sync"
+
+	"github.com/c-bata/goptuna"
+)
+
+// artifactFile is a file written by a trial under ./data/optuna.
+type artifactFile struct {
+	path  string
+	size  int64
+	trial int
+}
+
+// artifactJanitor keeps the total size of the files written by the trials
+// under -max-artifact-bytes. The files are tracked as the runs finish, and
+// the oldest are deleted in the background once the total exceeds the cap,
+// except the files of the best trial.
+type artifactJanitor struct {
+	cfg      *Config
+	study    *goptuna.Study
+	maxBytes int64
+
+	mu    sync.Mutex
+	files []artifactFile
+	total int64
+
+	request chan struct{}
+	done    chan struct{}
+}
+
+func newArtifactJanitor(cfg *Config, study *goptuna.Study) *artifactJanitor {
+	j := &artifactJanitor{
+		cfg:      cfg,
+		study:    study,
+		maxBytes: cfg.MaxArtifactBytes,
+		request:  make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	go j.loop()
+	return j
+}
+
+// Track records the files of the run which are left on disk.
+func (j *artifactJanitor) Track(run trainRun) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, path := range []string{run.jsonMetaPath, run.modelPath, run.predPath} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		j.files = append(j.files, artifactFile{path: path, size: fi.Size(), trial: run.trial})
+		j.total += fi.Size()
+	}
+}
+
+// TrialFinished requests a cleanup if the files exceed the cap. It's called
+// after the trial is stored, so that a new best trial is kept.
+func (j *artifactJanitor) TrialFinished() {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	over := j.total > j.maxBytes
+	j.mu.Unlock()
+	if !over {
+		return
+	}
+	select {
+	case j.request <- struct{}{}:
+	default:
+		// a cleanup is already pending.
+	}
+}
+
+// Close waits for the running cleanup.
+func (j *artifactJanitor) Close() {
+	if j == nil {
+		return
+	}
+	close(j.request)
+	<-j.done
+}
+
+func (j *artifactJanitor) loop() {
+	defer close(j.done)
+	for range j.request {
+		best := -1
+		if t, err := getBestTrial(j.study, bestFilter(j.cfg), j.cfg.TieBreak); err == nil {
+			best = t.Number
+		} else if err != goptuna.ErrNoCompletedTrials {
+			log.Print("failed to get the best trial to keep its artifacts:", err)
+			continue
+		}
+		j.cleanup(best)
+	}
+}
+
+// cleanup deletes the oldest files except the ones of the best trial until
+// the total is within the cap.
+func (j *artifactJanitor) cleanup(best int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	kept := j.files[:0]
+	var deleted int
+	var freed int64
+	for _, f := range j.files {
+		if j.total <= j.maxBytes || f.trial == best {
+			kept = append(kept, f)
+			continue
+		}
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			log.Print("failed to delete the artifact:", err)
+			kept = append(kept, f)
+			continue
+		}
+		j.total -= f.size
+		freed += f.size
+		deleted++
+	}
+	j.files = kept
+	if deleted > 0 {
+		log.Printf("deleted %d artifacts of %d bytes to keep them under -max-artifact-bytes", deleted, freed)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/c-bata/goptuna"
+)
+
+// The op codes of Optuna's JournalStorage which -journal writes or reads.
+const (
+	journalCreateStudy = 0
+	journalDeleteStudy = 1
+	journalCreateTrial = 4
+)
+
+// journalNumberKey is the system attr of the journal records holding the
+// number of the trial in goptuna, because Optuna numbers the trials of a
+// journal in the order they're appended.
+const journalNumberKey = "goptuna_number"
+
+// optunaJournalStates maps goptuna's trial states to the ones of Optuna's
+// journal.
+var optunaJournalStates = map[goptuna.TrialState]int{
+	goptuna.TrialStateComplete: 1,
+	goptuna.TrialStatePruned:   2,
+	goptuna.TrialStateFail:     3,
+}
+
+// journalWriter appends the finished trials to a file of Optuna's
+// JournalStorage, so that Optuna's tools reading journals can monitor the
+// sweep. It's a one-way writer for a single process: the journal isn't
+// locked, and each trial is appended as a complete CREATE_TRIAL record once
+// it has finished.
+type journalWriter struct {
+	study *goptuna.Study
+	path  string
+	// workerID is Optuna's worker_id of the records.
+	workerID string
+
+	mu      sync.Mutex
+	studyID int
+	// written are the numbers of the trials in the journal.
+	written map[int]bool
+}
+
+// newJournalWriter opens the journal at path, and appends the study unless
+// the journal has it. The trials in the journal already are skipped, so that
+// a resumed study appends the new ones only.
+func newJournalWriter(cfg *Config, study *goptuna.Study) (*journalWriter, error) {
+	w := &journalWriter{
+		study:    study,
+		path:     cfg.Journal,
+		workerID: cfg.RunID,
+		studyID:  -1,
+		written:  make(map[int]bool),
+	}
+	if err := w.replay(cfg.StudyName); err != nil {
+		return nil, err
+	}
+	if w.studyID >= 0 {
+		return w, nil
+	}
+	direction := 1
+	if study.Direction() == goptuna.StudyDirectionMaximize {
+		direction = 2
+	}
+	err := w.append(map[string]interface{}{
+		"op_code":    journalCreateStudy,
+		"worker_id":  w.workerID,
+		"study_name": cfg.StudyName,
+		"directions": []int{direction},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return w, w.replay(cfg.StudyName)
+}
+
+// replay reads the journal for the ID of the study and its trials, assigning
+// the study IDs as Optuna does.
+func (w *journalWriter) replay(studyName string) error {
+	f, err := os.Open(w.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ids := make(map[string]int)
+	nextID := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var record struct {
+			OpCode      int               `json:"op_code"`
+			StudyID     int               `json:"study_id"`
+			StudyName   string            `json:"study_name"`
+			SystemAttrs map[string]string `json:"system_attrs"`
+		}
+		if err = json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return fmt.Errorf("line %d of %s is not a journal record: %s", line, w.path, err)
+		}
+		switch record.OpCode {
+		case journalCreateStudy:
+			// Optuna ignores the duplicated studies.
+			if _, ok := ids[record.StudyName]; !ok {
+				ids[record.StudyName] = nextID
+				nextID++
+			}
+		case journalDeleteStudy:
+			for name, id := range ids {
+				if id == record.StudyID {
+					delete(ids, name)
+				}
+			}
+			if record.StudyID == w.studyID {
+				w.studyID = -1
+				w.written = make(map[int]bool)
+			}
+		case journalCreateTrial:
+			if id, ok := ids[studyName]; !ok || id != record.StudyID {
+				continue
+			}
+			if n, err := strconv.Atoi(record.SystemAttrs[journalNumberKey]); err == nil {
+				w.written[n] = true
+			}
+		}
+		if id, ok := ids[studyName]; ok {
+			w.studyID = id
+		}
+	}
+	return scanner.Err()
+}
+
+// TrialFinished appends the finished trials which the journal doesn't have.
+func (w *journalWriter) TrialFinished() {
+	if w == nil {
+		return
+	}
+	trials, err := w.study.GetTrials()
+	if err != nil {
+		log.Print("failed to get the trials to journal:", err)
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, t := range trials {
+		if t.State == goptuna.TrialStateRunning || w.written[t.Number] {
+			continue
+		}
+		record, err := w.trialRecord(t)
+		if err == nil {
+			err = w.append(record)
+		}
+		if err != nil {
+			log.Printf("failed to journal trial %d: %s", t.Number, err)
+			continue
+		}
+		w.written[t.Number] = true
+	}
+}
+
+// trialRecord returns the CREATE_TRIAL record of the finished trial. JSON has
+// no NaN nor infinities, so the trials with those values are failed as
+// Optuna does for NaN, and such intermediate values are left out.
+func (w *journalWriter) trialRecord(t goptuna.FrozenTrial) (map[string]interface{}, error) {
+	state := optunaJournalStates[t.State]
+	var value interface{}
+	if t.State == goptuna.TrialStateComplete {
+		if math.IsNaN(t.Value) || math.IsInf(t.Value, 0) {
+			state = optunaJournalStates[goptuna.TrialStateFail]
+		} else {
+			value = t.Value
+		}
+	}
+	params := make(map[string]float64, len(t.Params))
+	distributions := make(map[string]string, len(t.Params))
+	for name, xr := range t.Params {
+		ir, dist, err := optunaParam(t.Distributions[name], xr)
+		if err != nil {
+			return nil, fmt.Errorf("param %q: %s", name, err)
+		}
+		params[name], distributions[name] = ir, dist
+	}
+	intermediate := make(map[string]float64, len(t.IntermediateValues))
+	for step, v := range t.IntermediateValues {
+		if !math.IsNaN(v) && !math.IsInf(v, 0) {
+			intermediate[strconv.Itoa(step)] = v
+		}
+	}
+	systemAttrs := make(map[string]string, len(t.SystemAttrs)+1)
+	for k, v := range t.SystemAttrs {
+		systemAttrs[k] = v
+	}
+	systemAttrs[journalNumberKey] = strconv.Itoa(t.Number)
+	return map[string]interface{}{
+		"op_code":             journalCreateTrial,
+		"worker_id":           w.workerID,
+		"study_id":            w.studyID,
+		"datetime_start":      journalDatetime(t.DatetimeStart),
+		"datetime_complete":   journalDatetime(t.DatetimeComplete),
+		"state":               state,
+		"value":               value,
+		"values":              nil,
+		"distributions":       distributions,
+		"params":              params,
+		"user_attrs":          t.UserAttrs,
+		"system_attrs":        systemAttrs,
+		"intermediate_values": intermediate,
+	}, nil
+}
+
+// append writes the record as a line at the end of the journal.
+func (w *journalWriter) append(record map[string]interface{}) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// journalDatetime formats the time as Python's isoformat of a naive local
+// datetime, which Optuna writes.
+func journalDatetime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.Local().Format("2006-01-02T15:04:05.000000")
+}
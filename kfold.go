@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FoldPaths are the training and the validation data of a fold of
+// SplitKFold.
+type FoldPaths struct {
+	Train string
+	Valid string
+}
+
+// SplitKFold partitions the libffm data at path into k folds, and writes the
+// training and the validation data of each fold to outDir, named like
+// "train2-fold-0-valid.txt". Each example is in the validation data of
+// exactly one fold and in the training data of the others. The examples are
+// shuffled by the seed, so the same seed writes the same folds, and the sizes
+// of the validation data differ by at most one when the examples aren't
+// divisible by k. Empty lines are skipped.
+func SplitKFold(path string, k int, seed int64, outDir string) ([]FoldPaths, error) {
+	if k < 2 {
+		return nil, fmt.Errorf("k must be at least 2: %d", k)
+	}
+	n, err := countExamples(path)
+	if err != nil {
+		return nil, err
+	}
+	if n < k {
+		return nil, fmt.Errorf("%s has %d examples, fewer than the %d folds", path, n, k)
+	}
+	// the i-th example of the shuffled order is validated in fold i%k.
+	folds := make([]int32, n)
+	for i, j := range rand.New(rand.NewSource(seed)).Perm(n) {
+		folds[j] = int32(i % k)
+	}
+
+	if err = os.MkdirAll(outDir, 0755); err != nil {
+		return nil, err
+	}
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(filepath.Base(path), ext)
+	paths := make([]FoldPaths, k)
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	create := func(path string) (*bufio.Writer, error) {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+		return bufio.NewWriter(f), nil
+	}
+	trains := make([]*bufio.Writer, k)
+	valids := make([]*bufio.Writer, k)
+	for i := range paths {
+		paths[i] = FoldPaths{
+			Train: filepath.Join(outDir, fmt.Sprintf("%s-fold-%d-train%s", base, i, ext)),
+			Valid: filepath.Join(outDir, fmt.Sprintf("%s-fold-%d-valid%s", base, i, ext)),
+		}
+		if trains[i], err = create(paths[i].Train); err != nil {
+			return nil, err
+		}
+		if valids[i], err = create(paths[i].Valid); err != nil {
+			return nil, err
+		}
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	example := 0
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if example >= n {
+			return nil, fmt.Errorf("%s changed while splitting it", path)
+		}
+		for i := 0; i < k; i++ {
+			w := trains[i]
+			if int(folds[example]) == i {
+				w = valids[i]
+			}
+			w.Write(line)
+			w.WriteByte('\n')
+		}
+		example++
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if example != n {
+		return nil, fmt.Errorf("%s changed while splitting it", path)
+	}
+	for i := range paths {
+		if err = trains[i].Flush(); err == nil {
+			err = valids[i].Flush()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range files {
+		if err = f.Close(); err != nil {
+			return nil, err
+		}
+	}
+	files = nil
+	return paths, nil
+}
+
+// countExamples returns the number of the non-empty lines of the data.
+func countExamples(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	n := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) > 0 {
+			n++
+		}
+	}
+	return n, scanner.Err()
+}
+
+// splitFolds writes the -split-folds folds of the training data to
+// -fold-dir and prints their paths.
+func splitFolds(cfg *Config) error {
+	paths, err := SplitKFold(cfg.TrainPath, cfg.SplitFolds, cfg.DataSeed, cfg.FoldDir)
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		fmt.Printf("%s %s\n", p.Train, p.Valid)
+	}
+	return nil
+}
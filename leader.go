@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/c-bata/goptuna"
+)
+
+// leaderAttrPrefix is a prefix of the study system attr which the leader of
+// a run holds.
+const leaderAttrPrefix = "leader:"
+
+// leaderPollInterval is the interval to check whether the other workers are
+// done.
+const leaderPollInterval = 5 * time.Second
+
+// electLeader elects one of the workers which share the run ID to retrain and
+// report after the sweep. The storage inserts attrs with a unique index, so
+// only the first worker can set the attr.
+func electLeader(study *goptuna.Study, runID string) (bool, error) {
+	key := leaderAttrPrefix + runID
+	// look up first to avoid the constraint error in the usual case.
+	if elected, err := hasStudySystemAttr(study, key); err != nil || elected {
+		return false, err
+	}
+	host, _ := os.Hostname()
+	err := study.SetSystemAttr(key, fmt.Sprintf("%s:%d", host, os.Getpid()))
+	if err == nil {
+		return true, nil
+	}
+	if elected, aerr := hasStudySystemAttr(study, key); aerr != nil || elected {
+		return false, aerr
+	}
+	return false, err
+}
+
+func hasStudySystemAttr(study *goptuna.Study, key string) (bool, error) {
+	attrs, err := study.GetSystemAttrs()
+	if err != nil {
+		return false, err
+	}
+	_, ok := attrs[key]
+	return ok, nil
+}
+
+// The prefixes of the study system attrs of a worker of a run, a key like
+// "worker:<run ID>:<host>:<pid>", which it sets when it starts and when it's
+// done with its trials.
+const (
+	workerAttrPrefix     = "worker:"
+	workerDoneAttrPrefix = "worker-done:"
+)
+
+// workerID identifies the process among the workers of a run.
+func workerID() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
+
+// registerWorker marks the worker as a worker of the run, which the leader
+// waits for.
+func registerWorker(study *goptuna.Study, runID, worker string) error {
+	return study.SetSystemAttr(workerAttrPrefix+runID+":"+worker, time.Now().Format(time.RFC3339))
+}
+
+// markWorkerDone marks the worker of the run as done with its trials.
+func markWorkerDone(study *goptuna.Study, runID, worker string) error {
+	return study.SetSystemAttr(workerDoneAttrPrefix+runID+":"+worker, time.Now().Format(time.RFC3339))
+}
+
+// waitForWorkers waits until the registered workers of the run are done with
+// their trials, so that the leader reports all of them. The running trials
+// of other runs, like the stale ones of a crashed run, aren't waited for.
+func waitForWorkers(ctx context.Context, study *goptuna.Study, runID string) error {
+	prefix := workerAttrPrefix + runID + ":"
+	for {
+		attrs, err := study.GetSystemAttrs()
+		if err != nil {
+			return err
+		}
+		var pending []string
+		for key := range attrs {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			worker := strings.TrimPrefix(key, prefix)
+			if _, ok := attrs[workerDoneAttrPrefix+runID+":"+worker]; !ok {
+				pending = append(pending, worker)
+			}
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		trials, err := study.GetTrials()
+		if err != nil {
+			return err
+		}
+		var running int
+		for i := range trials {
+			if trials[i].State == goptuna.TrialStateRunning && trials[i].SystemAttrs[runIDAttrKey] == runID {
+				running++
+			}
+		}
+		sort.Strings(pending)
+		log.Printf("waiting for the workers %s with %d running trials of the run", strings.Join(pending, ", "), running)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(leaderPollInterval):
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"hash/fnv"
+	"os"
+)
+
+// countLeakage returns the number of the validation lines which also appear
+// in the training data, and the number of the validation lines. Only 64-bit
+// hashes of the validation lines are kept in memory, so hash collisions may
+// overcount by a negligible amount.
+func countLeakage(trainPath, validPath string) (int, int, error) {
+	valid := make(map[uint64]bool, 1024)
+	var total int
+	err := scanLines(validPath, func(line []byte) {
+		valid[hashLine(line)] = false
+		total++
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var overlap int
+	err = scanLines(trainPath, func(line []byte) {
+		h := hashLine(line)
+		if seen, ok := valid[h]; ok && !seen {
+			valid[h] = true
+			overlap++
+		}
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return overlap, total, nil
+}
+
+// scanLines calls fn with each non-empty line without surrounding spaces.
+func scanLines(path string, fn func(line []byte)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) > 0 {
+			fn(line)
+		}
+	}
+	return scanner.Err()
+}
+
+func hashLine(line []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(line)
+	return h.Sum64()
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// libffmTrainer is the default Trainer, which runs ffm-train -repeats times
+// and evaluates the metric by the JSON meta or ffm-predict.
+type libffmTrainer struct {
+	r *runner
+}
+
+// Train trains on the data of paths with the params "lambda", "eta",
+// "latent", and the schedule params and "train_seed" if their flags are set.
+// The metric is the mean over the repeats, and stdout and stderr are the ones
+// of all the runs. A run over -trial-timeout returns the best va_logloss it
+// printed, for -timeout-action prune.
+func (t libffmTrainer) Train(ctx context.Context, params map[string]interface{}, paths TrainPaths) (float64, map[string]string, error) {
+	r, cfg := t.r, t.r.cfg
+	lmd, eta, latent, err := effectiveParams(params)
+	if err != nil {
+		return failedValue, nil, err
+	}
+	var trainSeed int
+	if cfg.TrainSeedFlag != "" {
+		if trainSeed, err = intParam(params, "train_seed"); err != nil {
+			return failedValue, nil, err
+		}
+	}
+	schedule, err := scheduleArgs(cfg, params)
+	if err != nil {
+		return failedValue, nil, err
+	}
+	data := dataPaths{train: paths.Train, valid: paths.Valid}
+	// each repeat has its own files so that the runs don't clobber each other.
+	runs := make([]trainRun, cfg.Repeats)
+	commands := make([]string, cfg.Repeats)
+	for i := range runs {
+		name := strconv.Itoa(paths.Trial)
+		if cfg.Repeats > 1 {
+			name = fmt.Sprintf("%d-%d", paths.Trial, i)
+		}
+		runs[i] = r.trainRun(paths.Trial, name, lmd, eta, latent, trainSeed+i, schedule, data)
+		commands[i] = shellJoin(append([]string{cfg.TrainBin}, runs[i].args...))
+	}
+	attrs := map[string]string{
+		// the exact strings passed to ffm-train, which may be rounded.
+		"lambda_arg": formatFloatArg(lmd, cfg.ParamPrecision),
+		"eta_arg":    formatFloatArg(eta, cfg.ParamPrecision),
+		"command":    strings.Join(commands, "\n"),
+	}
+
+	evals := make([]evaluation, len(runs))
+	for i := range runs {
+		evals[i], err = r.evaluate(ctx, runs[i])
+		if err == errDiverged {
+			attrs["stdout"] = encodeOutput(evals[i].stdout, cfg.CompressOutput)
+			attrs["stderr"] = encodeOutput(evals[i].stderr, cfg.CompressOutput)
+			return failedValue, attrs, err
+		}
+		if errorCategory(err) == failureTimeout {
+			attrs["stdout"] = encodeOutput(evals[i].stdout, cfg.CompressOutput)
+			attrs["stderr"] = encodeOutput(evals[i].stderr, cfg.CompressOutput)
+			iteration, loss, ok := bestIntermediate(evals[i].stdout)
+			if !ok {
+				return failedValue, attrs, err
+			}
+			attrs["best_iteration"] = strconv.Itoa(iteration)
+			attrs["va_loss"] = fmt.Sprintf("%f", loss)
+			return loss, attrs, err
+		}
+		if err != nil {
+			return failedValue, attrs, err
+		}
+	}
+
+	var stdouts, stderrs []string
+	var iterations, vaLosses, values []float64
+	var maxRSS int64
+	var hasRSS bool
+	var cached int
+	for _, e := range evals {
+		if e.cached {
+			cached++
+		}
+		stdouts = append(stdouts, e.stdout)
+		stderrs = append(stderrs, e.stderr)
+		iterations = append(iterations, float64(e.bestIteration))
+		vaLosses = append(vaLosses, e.vaLoss)
+		values = append(values, e.value)
+		if e.hasRSS && e.maxRSS > maxRSS {
+			maxRSS, hasRSS = e.maxRSS, true
+		}
+	}
+	bestIteration, _ := meanStd(iterations)
+	vaLoss, vaLossStd := meanStd(vaLosses)
+	value, valueStd := meanStd(values)
+
+	if cached > 0 {
+		attrs["cached_runs"] = strconv.Itoa(cached)
+	}
+	attrs["best_iteration"] = fmt.Sprintf("%d", int(math.Round(bestIteration)))
+	if hasRSS {
+		attrs["max_rss_kb"] = fmt.Sprintf("%d", maxRSS)
+	}
+	attrs["stdout"] = encodeOutput(strings.Join(stdouts, "\n"), cfg.CompressOutput)
+	attrs["stderr"] = encodeOutput(strings.Join(stderrs, "\n"), cfg.CompressOutput)
+	attrs["va_loss"] = fmt.Sprintf("%f", vaLoss)
+	if cfg.Repeats > 1 {
+		attrs["va_loss_std"] = fmt.Sprintf("%f", vaLossStd)
+	}
+	if metricNeedsPrediction(cfg.Metric) {
+		attrs[cfg.Metric] = fmt.Sprintf("%f", value)
+		if cfg.Repeats > 1 {
+			attrs[cfg.Metric+"_std"] = fmt.Sprintf("%f", valueStd)
+		}
+	}
+	for _, name := range cfg.RecordMetrics {
+		extras := make([]float64, len(evals))
+		for i, e := range evals {
+			extras[i] = e.extra[name]
+		}
+		mean, _ := meanStd(extras)
+		attrs[name] = fmt.Sprintf("%f", mean)
+	}
+	return value, attrs, nil
+}
+
+// trainRun is the files and the arguments of an ffm-train run.
+type trainRun struct {
+	// trial is the number of the trial, and name names the files of the run.
+	trial int
+	name  string
+	// cacheKey is the key of -cache-dir.
+	cacheKey string
+	// validPath is the validation data, which ffm-predict reads too.
+	validPath    string
+	args         []string
+	jsonMetaPath string
+	modelPath    string
+	predPath     string
+}
+
+func (r *runner) trainRun(number int, name string, lmd, eta float64, latent, trainSeed int, schedule []string, data dataPaths) trainRun {
+	cfg := r.cfg
+	run := trainRun{
+		trial:        number,
+		name:         name,
+		validPath:    data.valid,
+		jsonMetaPath: fmt.Sprintf("./data/optuna/ffm-meta-%s.json", name),
+		modelPath:    fmt.Sprintf("./data/optuna/ffm-model-%s.model", name),
+		predPath:     fmt.Sprintf("./data/optuna/ffm-pred-%s.txt", name),
+	}
+
+	// the arguments which determine the result, unlike the paths.
+	hyperParams := append(hyperParamArgs(lmd, eta, latent, cfg.ParamPrecision), schedule...)
+	if cfg.TrainSeedFlag != "" {
+		hyperParams = append(hyperParams, cfg.TrainSeedFlag, strconv.Itoa(trainSeed))
+	}
+	if r.cache != nil {
+		key := hyperParams
+		if data.train != cfg.TrainPath {
+			// the copy with the selected fields is named by the fields.
+			key = append(key[:len(key):len(key)], data.train)
+		}
+		run.cacheKey = r.cache.Key(key)
+	}
+
+	args := []string{
+		"-p", data.valid,
+		"--auto-stop", "--auto-stop-threshold", "3",
+	}
+	args = append(args, hyperParams...)
+	args = append(args, "-t", "500", "--json-meta", run.jsonMetaPath)
+	if cfg.PrecomputeBin {
+		args = append(args, "--on-disk")
+	}
+	// the model path is always given because ffm-train defaults to one next
+	// to the training data, which the concurrent trials would clobber.
+	args = append(args, data.train, run.modelPath)
+	run.args = args
+	return run
+}
+
+// evaluation is the result of an ffm-train run.
+type evaluation struct {
+	bestIteration int
+	vaLoss        float64
+	// value is the configured metric.
+	value  float64
+	maxRSS int64
+	hasRSS bool
+	stdout string
+	stderr string
+	// cached is set if it's read from -cache-dir.
+	cached bool
+	// extra is the metrics of Config.RecordMetrics.
+	extra map[string]float64
+}
+
+// evaluate runs ffm-train and computes the configured metric.
+func (r *runner) evaluate(ctx context.Context, run trainRun) (evaluation, error) {
+	cfg := r.cfg
+	defer r.janitor.Track(run)
+	if r.cache != nil {
+		if e, ok := r.cache.Get(run.cacheKey); ok {
+			return e, nil
+		}
+	}
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	defer func() { r.storeRun(run, stdout.Bytes(), stderr.Bytes()) }()
+	var state *os.ProcessState
+	// the warm pool gets the args of ffm-train; the server itself is wrapped.
+	bin, args := cfg.TrainBin, run.args
+	if r.server == nil {
+		bin, args = wrapCommand(cfg, bin, args)
+		set := r.cores.Acquire()
+		defer r.cores.Release(set)
+		bin, args = r.cores.Pin(set, bin, args)
+	}
+	if err := r.cmdLog.Log(run.trial, bin, args); err != nil {
+		return evaluation{}, err
+	}
+	start := time.Now()
+	if r.server != nil {
+		resp, err := r.server.Train(ctx, run.args)
+		if err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				err = fmt.Errorf("timed out after -trial-timeout=%s", cfg.TrialTimeout)
+				return evaluation{}, categorize(failureTimeout, err)
+			} else if ctx.Err() != nil {
+				return evaluation{}, categorize(failureCanceled, err)
+			}
+			return evaluation{}, categorize(failureServer, err)
+		}
+		stdout.WriteString(resp.Stdout)
+		stderr.WriteString(resp.Stderr)
+		if !cfg.OKExitCodes[resp.ExitCode] {
+			return evaluation{}, categorize(failureExitCode, fmt.Errorf("ffm-train exited with %d: %s", resp.ExitCode, stderr))
+		}
+	} else {
+		cmd := exec.CommandContext(ctx, bin, args...)
+		cmd.Env = trainEnv(cfg)
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+
+		if err := r.procs.Acquire(ctx); err != nil {
+			if err == context.DeadlineExceeded {
+				err = fmt.Errorf("timed out after -trial-timeout=%s waiting for -max-procs", cfg.TrialTimeout)
+				return evaluation{}, categorize(failureTimeout, err)
+			}
+			return evaluation{}, categorize(failureCanceled, err)
+		}
+		// the wait for the slot isn't the time of ffm-train.
+		start = time.Now()
+		runErr := cmd.Run()
+		r.procs.Release()
+		err := checkExitCode(cfg, runErr)
+		state = cmd.ProcessState
+		if err != nil {
+			category := failureExec
+			if ctx.Err() == context.DeadlineExceeded {
+				err = fmt.Errorf("timed out after -trial-timeout=%s", cfg.TrialTimeout)
+				return evaluation{stdout: stdout.String(), stderr: stderr.String()}, categorize(failureTimeout, err)
+			} else if ctx.Err() != nil {
+				category = failureCanceled
+			} else if _, ok := runErr.(*exec.ExitError); ok || runErr == nil {
+				category = failureExitCode
+			}
+			return evaluation{}, categorize(category, fmt.Errorf("%s: %s", err, stderr))
+		}
+	}
+	if r.profiler != nil {
+		r.profiler.ObserveTrain(time.Since(start))
+	}
+
+	if !metricNeedsPrediction(cfg.Metric) {
+		// only ffm-predict reads the model of a trial.
+		os.Remove(run.modelPath)
+	}
+
+	jsonStr, err := ioutil.ReadFile(run.jsonMetaPath)
+	if os.IsNotExist(err) {
+		return evaluation{}, categorize(failureMetaNotWritten, fmt.Errorf("failed to read json: %s", err))
+	}
+	if err != nil {
+		return evaluation{}, fmt.Errorf("failed to read json: %s", err)
+	}
+	bestIteration, bestVALoss, err := parseJSONMeta(jsonStr)
+	if err != nil {
+		return evaluation{}, categorize(failureMetaParse, fmt.Errorf("failed to read json: %s", err))
+	}
+	if bestIteration == 0 && bestVALoss == 0 {
+		return evaluation{}, categorize(failureMetaParse, errors.New("failed to open json meta"))
+	}
+
+	e := evaluation{
+		bestIteration: bestIteration,
+		vaLoss:        bestVALoss,
+		value:         bestVALoss,
+		stdout:        stdout.String(),
+		stderr:        stderr.String(),
+	}
+	e.maxRSS, e.hasRSS = maxRSSKB(state)
+	if isDiverged(e.vaLoss) {
+		return e, errDiverged
+	}
+	if metricNeedsPrediction(cfg.Metric) {
+		e.value, e.extra, err = predictMetric(ctx, cfg, r.cmdLog, run.trial, run.validPath, run.modelPath, run.predPath)
+		if err != nil {
+			return evaluation{}, categorize(failurePredict, err)
+		}
+		if isDiverged(e.value) {
+			return e, errDiverged
+		}
+	}
+	if r.cache != nil {
+		if err = r.cache.Put(run.cacheKey, e); err != nil {
+			log.Print("failed to cache the evaluation:", err)
+		}
+	}
+	return e, nil
+}
+
+// checkExitCode returns nil if cmd.Run() returned nil or an exit code in
+// cfg.OKExitCodes, and the error otherwise, like when ffm-train is killed.
+func checkExitCode(cfg *Config, err error) error {
+	code := 0
+	if err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return fmt.Errorf("failed to run ffm-train: %s", err)
+		}
+		code = exitErr.ExitCode()
+	}
+	if !cfg.OKExitCodes[code] {
+		if err == nil {
+			return errors.New("ffm-train exited with 0, which isn't in -ok-exit-codes")
+		}
+		return fmt.Errorf("ffm-train exited with %s", err)
+	}
+	return nil
+}
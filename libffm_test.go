@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConcurrentTrialsKeepTheirModels(t *testing.T) {
+	defer inSweepDir(t)()
+	// ffm-predict reads the models of logloss, so they're kept.
+	cfg := sweepConfig(t, "-n-trials", "2", "-concurrency", "2", "-metric", "logloss")
+	if err := RunStudy(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	models, err := filepath.Glob(filepath.Join("data", "optuna", "ffm-model-*.model"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(models) != 2 {
+		t.Fatalf("got the models %v, want one per trial", models)
+	}
+	for _, path := range models {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the stub writes the arguments of the run into its model.
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "ffm-model-"), ".model")
+		args := string(b)
+		if !strings.Contains(args, "ffm-meta-"+name+".json") || !strings.HasSuffix(strings.TrimSpace(args), "ffm-model-"+name+".model") {
+			t.Errorf("%s has the arguments of another run: %s", path, args)
+		}
+	}
+}
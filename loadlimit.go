@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// loadCheckInterval is the interval of reading the load average for
+// -max-load. The 1-minute load average lags behind, so checking more often
+// would only react to the same load repeatedly.
+const loadCheckInterval = 15 * time.Second
+
+// loadLimiter limits the number of the trials run at once by the load average
+// of the machine. A monitor lowers the capacity by one while the load is
+// above maxLoad, and raises it by one while the load is below maxLoad-1, so
+// that the workers share the machine with the other processes. The capacity
+// is between 1 and the number of the workers.
+type loadLimiter struct {
+	maxLoad float64
+	workers int
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	capacity int
+	running  int
+	done     bool
+}
+
+func newLoadLimiter(ctx context.Context, maxLoad float64, workers int) (*loadLimiter, error) {
+	l := &loadLimiter{
+		maxLoad:  maxLoad,
+		workers:  workers,
+		capacity: workers,
+	}
+	l.cond = sync.NewCond(&l.mu)
+	if _, err := readLoadAvg(); err != nil {
+		return nil, err
+	}
+	go l.monitor(ctx)
+	return l, nil
+}
+
+func (l *loadLimiter) monitor(ctx context.Context) {
+	ticker := time.NewTicker(loadCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			l.mu.Lock()
+			l.done = true
+			l.mu.Unlock()
+			l.cond.Broadcast()
+			return
+		case <-ticker.C:
+		}
+		load, err := readLoadAvg()
+		if err != nil {
+			log.Print("failed to read the load average:", err)
+			continue
+		}
+		l.mu.Lock()
+		capacity := l.capacity
+		if load > l.maxLoad && capacity > 1 {
+			capacity--
+		} else if load < l.maxLoad-1 && capacity < l.workers {
+			capacity++
+		}
+		if capacity != l.capacity {
+			log.Printf("load average %.2f: running at most %d trials at once", load, capacity)
+			l.capacity = capacity
+		}
+		l.mu.Unlock()
+		l.cond.Broadcast()
+	}
+}
+
+// Acquire blocks until a trial can start. It returns false if ctx is done.
+func (l *loadLimiter) Acquire() bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for !l.done && l.running >= l.capacity {
+		l.cond.Wait()
+	}
+	if l.done {
+		return false
+	}
+	l.running++
+	return true
+}
+
+// Release is called when a trial acquired by Acquire finishes.
+func (l *loadLimiter) Release() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.running--
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+// readLoadAvg reads the 1-minute load average from /proc/loadavg, which only
+// Linux provides.
+func readLoadAvg() (float64, error) {
+	b, err := ioutil.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("unexpected /proc/loadavg: %q", b)
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
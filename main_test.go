@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// stubTrain is an ffm-train which appends a line to runs.txt per run, writes
+// the JSON meta and writes its arguments into the model.
+const stubTrain = `#!/bin/sh
+if [ "$1" = "--version" ]; then
+	echo "libffm stub"
+	exit 0
+fi
+args="$*"
+echo run >> runs.txt
+while [ $# -gt 0 ]; do
+	case "$1" in
+	--json-meta) meta=$2; shift ;;
+	esac
+	model=$1
+	shift
+done
+sleep 0.2
+echo '{"best_iteration": 3, "best_va_loss": 0.5}' > "$meta"
+echo "$args" > "$model"
+`
+
+// stubPredict is an ffm-predict which predicts 0.5 for every example.
+const stubPredict = `#!/bin/sh
+while read -r line; do
+	echo 0.5
+done < "$1" > "$3"
+`
+
+// inSweepDir changes to a new directory with the data of a sweep and the
+// stubs of ffm-train and ffm-predict, and returns the function which changes
+// back and removes it.
+func inSweepDir(t *testing.T) func() {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the stubs of libffm are shell scripts")
+	}
+	dir, err := ioutil.TempDir("", "goptuna-libffm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	cleanup := func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+
+	files := map[string]string{
+		"ffm-train":                         stubTrain,
+		"ffm-predict":                       stubPredict,
+		filepath.Join("data", "train2.txt"): strings.Repeat("1 0:1:1 1:3:1\n0 0:2:1 1:4:1\n", 10),
+		filepath.Join("data", "valid2.txt"): strings.Repeat("1 0:1:1 1:3:1\n0 0:2:1 1:4:1\n", 2),
+	}
+	if err = os.MkdirAll(filepath.Join("data", "optuna"), 0755); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err = ioutil.WriteFile(name, []byte(content), 0755); err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+	}
+	return cleanup
+}
+
+// sweepConfig returns the config of the flags for a sweep of the stubs on a
+// storage in memory.
+func sweepConfig(t *testing.T, args ...string) *Config {
+	t.Helper()
+	cfg, err := parseFlags(append([]string{
+		"-ffm-train-bin", "./ffm-train",
+		"-dsn", ":memory:",
+		"-db-lock", "off",
+		"-probe-json-meta=false",
+		"-no-signal-handler",
+	}, args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestDefaultConcurrency(t *testing.T) {
+	if n := defaultConcurrency(); n < 1 {
+		t.Errorf("defaultConcurrency() = %d, want at least 1", n)
+	}
+}
+
+func TestRunStudyWithoutConcurrency(t *testing.T) {
+	defer inSweepDir(t)()
+	cfg := sweepConfig(t, "-n-trials", "1", "-concurrency", "0")
+	if err := RunStudy(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	runs, err := ioutil.ReadFile("runs.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(runs), "run\n"); n != 1 {
+		t.Errorf("ffm-train ran %d times with -concurrency 0, want 1", n)
+	}
+}
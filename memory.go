@@ -0,0 +1,80 @@
+package main
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/c-bata/goptuna"
+)
+
+// memoryMinObservations is the number of trials with the max RSS needed to
+// fit the memory model of -max-memory-mb.
+const memoryMinObservations = 5
+
+// predictMemoryMB predicts the max RSS of ffm-train with the latent from the
+// max_rss_kb attrs of the finished trials. With enough observations of at
+// least two latents, it fits rss = a + b*latent by least squares and adds the
+// largest residual as a margin. Before that, it conservatively assumes that
+// the memory is proportional to latent with the largest ratio observed,
+// which overestimates because of the memory independent of latent. It returns
+// false if nothing is observed yet.
+func predictMemoryMB(study *goptuna.Study, latent int) (float64, bool, error) {
+	trials, err := study.GetTrials()
+	if err != nil {
+		return 0, false, err
+	}
+	var xs, ys []float64
+	for _, t := range trials {
+		kb, err := strconv.ParseFloat(t.UserAttrs["max_rss_kb"], 64)
+		if err != nil {
+			continue
+		}
+		params, err := trialParams(t)
+		if err != nil {
+			continue
+		}
+		k, err := intParam(params, "latent")
+		if err != nil || k <= 0 {
+			continue
+		}
+		xs = append(xs, float64(k))
+		ys = append(ys, kb/1024)
+	}
+	if len(xs) == 0 {
+		return 0, false, nil
+	}
+
+	x := float64(latent)
+	a, b, ok := fitLine(xs, ys)
+	if len(xs) < memoryMinObservations || !ok || b < 0 {
+		var ratio float64
+		for i := range xs {
+			ratio = math.Max(ratio, ys[i]/xs[i])
+		}
+		return ratio * x, true, nil
+	}
+	var margin float64
+	for i := range xs {
+		margin = math.Max(margin, ys[i]-(a+b*xs[i]))
+	}
+	return a + b*x + margin, true, nil
+}
+
+// fitLine fits y = a + b*x by least squares. It returns false if x has a
+// single value.
+func fitLine(xs, ys []float64) (float64, float64, bool) {
+	n := float64(len(xs))
+	var sx, sy, sxx, sxy float64
+	for i := range xs {
+		sx += xs[i]
+		sy += ys[i]
+		sxx += xs[i] * xs[i]
+		sxy += xs[i] * ys[i]
+	}
+	d := n*sxx - sx*sx
+	if d == 0 {
+		return 0, 0, false
+	}
+	b := (n*sxy - sx*sy) / d
+	return (sy - b*sx) / n, b, true
+}
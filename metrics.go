@@ -0,0 +1,702 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/c-bata/goptuna"
+)
+
+const (
+	// metricVALoss is the best validation logloss reported by ffm-train.
+	metricVALoss = "va_loss"
+	// metricLogLoss is the logloss of ffm-predict's output.
+	metricLogLoss = "logloss"
+	// metricAUC is the ROC AUC of ffm-predict's output.
+	metricAUC = "auc"
+	// metricRMSE and metricMAE are the errors of ffm-predict's output against
+	// the targets, for the forks of libffm which train regression.
+	metricRMSE = "rmse"
+	metricMAE  = "mae"
+	// metricBrier is the mean squared error of ffm-predict's probabilities.
+	metricBrier = "brier"
+	// metricECE is the expected calibration error of ffm-predict's
+	// probabilities over Config.ECEBins equal-width bins.
+	metricECE = "ece"
+	// metricPrecisionAtK and metricRecallAtK are the mean precision@K and
+	// recall@K of the groups of Config.GroupFile, K being Config.RankK.
+	metricPrecisionAtK = "precision_at_k"
+	metricRecallAtK    = "recall_at_k"
+)
+
+// newMetrics create the accumulator of a metric computed from the
+// predictions and the labels.
+var newMetrics = map[string]func(cfg *Config) metricAccumulator{
+	metricLogLoss:      func(cfg *Config) metricAccumulator { return &logLossAccumulator{} },
+	metricAUC:          func(cfg *Config) metricAccumulator { return newAUCAccumulator(cfg.AUCExactLimit) },
+	metricRMSE:         func(cfg *Config) metricAccumulator { return &rmseAccumulator{} },
+	metricMAE:          func(cfg *Config) metricAccumulator { return &maeAccumulator{} },
+	metricBrier:        func(cfg *Config) metricAccumulator { return &brierAccumulator{} },
+	metricECE:          func(cfg *Config) metricAccumulator { return newECEAccumulator(cfg.ECEBins) },
+	metricPrecisionAtK: func(cfg *Config) metricAccumulator { return newRankingAccumulator(cfg.RankK, false) },
+	metricRecallAtK:    func(cfg *Config) metricAccumulator { return newRankingAccumulator(cfg.RankK, true) },
+}
+
+// metricAccumulator computes a metric from the examples streamed one by one,
+// so that a large validation set isn't loaded into memory.
+type metricAccumulator interface {
+	Add(pred, label float64)
+	Value() float64
+}
+
+// checkedAccumulator is a metricAccumulator whose value may be undefined for
+// the examples, like AUC of a single class, which Err reports.
+type checkedAccumulator interface {
+	metricAccumulator
+	Err() error
+}
+
+// errSingleClass is the error of AUC of the validation data of a single
+// class, which isn't a divergence of the model.
+var errSingleClass = errors.New("AUC is undefined: the validation data has a single class")
+
+// mergeableAccumulator is a metricAccumulator which adds the examples of
+// another accumulator of the same metric, so that the examples are
+// accumulated in parallel by -metric-workers.
+type mergeableAccumulator interface {
+	metricAccumulator
+	Merge(other metricAccumulator)
+}
+
+// validateMetric returns an error if the metric is unknown.
+func validateMetric(metric string) error {
+	if metric == metricVALoss {
+		return nil
+	}
+	if _, ok := newMetrics[metric]; ok {
+		return nil
+	}
+	return fmt.Errorf("unknown metric %q", metric)
+}
+
+// metricNeedsPrediction reports whether the metric is computed from the
+// output of ffm-predict.
+func metricNeedsPrediction(metric string) bool {
+	return metric != metricVALoss
+}
+
+// metricIsRegression reports whether the metric is for continuous targets.
+func metricIsRegression(metric string) bool {
+	return metric == metricRMSE || metric == metricMAE
+}
+
+// metricIsClassification reports whether the metric is for the
+// probabilities of binary labels.
+func metricIsClassification(metric string) bool {
+	switch metric {
+	case metricVALoss, metricLogLoss, metricAUC, metricBrier, metricECE:
+		return true
+	}
+	return false
+}
+
+// checkLabels returns an error if the labels of the validation data don't
+// fit the metric: the classification metrics need binary labels, and the
+// regression metrics make no sense for them. The ranking metrics take any
+// label, a positive one being relevant, so graded relevance is fine.
+func checkLabels(validPath, metric string) error {
+	f, err := os.Open(validPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	labels := newColumnReader(f)
+	continuous := false
+	for {
+		label, ok, err := labels.Next()
+		if err != nil {
+			return fmt.Errorf("labels of %s must be numeric: %s", validPath, err)
+		}
+		if !ok {
+			break
+		}
+		if label != 0 && label != 1 && label != -1 {
+			if metricIsClassification(metric) {
+				return fmt.Errorf("labels of %s are continuous (e.g. %g), which metric %q doesn't support; use rmse or mae",
+					validPath, label, metric)
+			}
+			continuous = true
+		}
+	}
+	if metricIsRegression(metric) && !continuous {
+		return fmt.Errorf("labels of %s are binary, which metric %q doesn't fit; use logloss or auc", validPath, metric)
+	}
+	return nil
+}
+
+// metricDirection returns the direction to optimize the metric.
+func metricDirection(metric string) goptuna.StudyDirection {
+	switch metric {
+	case metricAUC, metricPrecisionAtK, metricRecallAtK:
+		return goptuna.StudyDirectionMaximize
+	}
+	return goptuna.StudyDirectionMinimize
+}
+
+// objectiveDirection returns the direction of the objective value.
+func objectiveDirection(cfg *Config) goptuna.StudyDirection {
+	if cfg.Normalize {
+		return goptuna.StudyDirectionMaximize
+	}
+	return metricDirection(cfg.Metric)
+}
+
+// baselineLogLoss returns the logloss of the constant predictor of the label
+// rate of the validation data.
+func baselineLogLoss(validPath string) (float64, error) {
+	f, err := os.Open(validPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read labels: %s", err)
+	}
+	defer f.Close()
+
+	var n, positives float64
+	labels := newColumnReader(f)
+	for {
+		label, ok, err := labels.Next()
+		if err != nil {
+			return 0, fmt.Errorf("failed to read labels: %s", err)
+		}
+		if !ok {
+			break
+		}
+		n++
+		if label > 0 {
+			positives++
+		}
+	}
+	if positives == 0 || positives == n {
+		return 0, errors.New("validation data has only one class")
+	}
+	rate := positives / n
+	return -(rate*math.Log(rate) + (1-rate)*math.Log(1-rate)), nil
+}
+
+// predictMetric runs ffm-predict on the validation data and computes the
+// metric, and the metrics of cfg.RecordMetrics in the same pass. The data
+// may be a copy of cfg.ValidPath with the fields of -select-fields, or the
+// validation split of -random-split.
+func predictMetric(ctx context.Context, cfg *Config, cmdLog *commandLog, trial int, validPath, modelPath, predPath string) (float64, map[string]float64, error) {
+	bin, args := wrapCommand(cfg, cfg.PredictBin, []string{validPath, modelPath, predPath})
+	if err := cmdLog.Log(trial, bin, args); err != nil {
+		return 0, nil, err
+	}
+	cmd := exec.CommandContext(ctx, bin, args...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return 0, nil, fmt.Errorf("ffm-predict exited with %s: %s", err, out)
+	}
+
+	// the accumulator of the metric is the first, followed by the ones of
+	// Config.RecordMetrics.
+	newAccs := func() []metricAccumulator {
+		accs := []metricAccumulator{newMetrics[cfg.Metric](cfg)}
+		for _, name := range cfg.RecordMetrics {
+			accs = append(accs, newMetrics[name](cfg))
+		}
+		return accs
+	}
+	accs := newAccs()
+	if cfg.MetricWorkers > 1 && cfg.GroupFile == "" && allMergeable(accs) {
+		accs, err := streamPredictionsParallel(predPath, validPath, cfg.MetricWorkers, newAccs)
+		if err != nil {
+			return 0, nil, err
+		}
+		return metricValues(cfg, accs)
+	}
+
+	var groups *groupReader
+	if cfg.GroupFile != "" {
+		f, err := os.Open(cfg.GroupFile)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to read groups: %s", err)
+		}
+		defer f.Close()
+		groups = newGroupReader(f)
+	}
+	var group string
+	var groupErr error
+	err := streamPredictions(predPath, validPath, func(pred, label float64) {
+		if groups != nil && groupErr == nil {
+			var ok bool
+			if group, ok, groupErr = groups.Next(); groupErr == nil && !ok {
+				groupErr = fmt.Errorf("%s has fewer groups than the validation examples", cfg.GroupFile)
+			}
+		}
+		for _, a := range accs {
+			if g, ok := a.(groupedAccumulator); ok {
+				g.AddGrouped(group, pred, label)
+			} else {
+				a.Add(pred, label)
+			}
+		}
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	if groups != nil && groupErr == nil {
+		if _, ok, err := groups.Next(); err != nil {
+			groupErr = err
+		} else if ok {
+			groupErr = fmt.Errorf("%s has more groups than the validation examples", cfg.GroupFile)
+		}
+	}
+	if groupErr != nil {
+		return 0, nil, fmt.Errorf("failed to read groups: %s", groupErr)
+	}
+	return metricValues(cfg, accs)
+}
+
+// allMergeable reports whether all the accumulators are mergeableAccumulator.
+func allMergeable(accs []metricAccumulator) bool {
+	for _, a := range accs {
+		if _, ok := a.(mergeableAccumulator); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// metricValues returns the value of the metric and the ones of
+// Config.RecordMetrics of the accumulators of predictMetric.
+func metricValues(cfg *Config, accs []metricAccumulator) (float64, map[string]float64, error) {
+	var values map[string]float64
+	if len(cfg.RecordMetrics) > 0 {
+		values = make(map[string]float64, len(cfg.RecordMetrics))
+		for i, name := range cfg.RecordMetrics {
+			values[name] = accs[i+1].Value()
+		}
+	}
+	if c, ok := accs[0].(checkedAccumulator); ok {
+		if err := c.Err(); err != nil {
+			return failedValue, nil, err
+		}
+	}
+	return accs[0].Value(), values, nil
+}
+
+// streamPredictions reads ffm-predict's output and the labels of the
+// validation data in lockstep and passes each pair to fn. ffm-predict may
+// skip malformed lines, so it returns an error if the number of predictions
+// doesn't match the number of examples instead of computing a misaligned
+// metric.
+func streamPredictions(predPath, validPath string, fn func(pred, label float64)) error {
+	pf, err := os.Open(predPath)
+	if err != nil {
+		return fmt.Errorf("failed to read predictions: %s", err)
+	}
+	defer pf.Close()
+	vf, err := os.Open(validPath)
+	if err != nil {
+		return fmt.Errorf("failed to read labels: %s", err)
+	}
+	defer vf.Close()
+
+	preds := newColumnReader(pf)
+	labels := newColumnReader(vf)
+	var nPreds, nLabels int
+	for {
+		pred, predOK, err := preds.Next()
+		if err != nil {
+			return fmt.Errorf("failed to read predictions: %s", err)
+		}
+		label, labelOK, err := labels.Next()
+		if err != nil {
+			return fmt.Errorf("failed to read labels: %s", err)
+		}
+		if predOK {
+			nPreds++
+		}
+		if labelOK {
+			nLabels++
+		}
+		if !predOK || !labelOK {
+			// count the rest to report the mismatch.
+			n, err := preds.Count()
+			if err != nil {
+				return fmt.Errorf("failed to read predictions: %s", err)
+			}
+			nPreds += n
+			if n, err = labels.Count(); err != nil {
+				return fmt.Errorf("failed to read labels: %s", err)
+			}
+			nLabels += n
+			break
+		}
+		fn(pred, label)
+	}
+	if nPreds != nLabels {
+		return fmt.Errorf(
+			"ffm-predict wrote %d predictions for %d validation examples",
+			nPreds, nLabels)
+	}
+	if nPreds == 0 {
+		return errors.New("no predictions")
+	}
+	return nil
+}
+
+// columnReader parses the first field of each non-empty line as a number.
+type columnReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func newColumnReader(r io.Reader) *columnReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return &columnReader{scanner: scanner}
+}
+
+// Next returns the value of the next line, or false at the end.
+func (c *columnReader) Next() (float64, bool, error) {
+	for c.scanner.Scan() {
+		c.line++
+		fields := strings.Fields(c.scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		v, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return 0, false, fmt.Errorf("line %d: %s", c.line, err)
+		}
+		return v, true, nil
+	}
+	return 0, false, c.scanner.Err()
+}
+
+// Count returns the number of the remaining values.
+func (c *columnReader) Count() (int, error) {
+	var n int
+	for {
+		_, ok, err := c.Next()
+		if err != nil || !ok {
+			return n, err
+		}
+		n++
+	}
+}
+
+type logLossAccumulator struct {
+	sum float64
+	n   int
+}
+
+func (a *logLossAccumulator) Add(pred, label float64) {
+	const eps = 1e-15
+	p := math.Min(math.Max(pred, eps), 1-eps)
+	if label > 0 {
+		a.sum -= math.Log(p)
+	} else {
+		a.sum -= math.Log(1 - p)
+	}
+	a.n++
+}
+
+func (a *logLossAccumulator) Value() float64 {
+	return a.sum / float64(a.n)
+}
+
+func (a *logLossAccumulator) Merge(other metricAccumulator) {
+	b := other.(*logLossAccumulator)
+	a.sum += b.sum
+	a.n += b.n
+}
+
+type rmseAccumulator struct {
+	sum float64
+	n   int
+}
+
+func (a *rmseAccumulator) Add(pred, label float64) {
+	a.sum += (pred - label) * (pred - label)
+	a.n++
+}
+
+func (a *rmseAccumulator) Value() float64 {
+	return math.Sqrt(a.sum / float64(a.n))
+}
+
+func (a *rmseAccumulator) Merge(other metricAccumulator) {
+	b := other.(*rmseAccumulator)
+	a.sum += b.sum
+	a.n += b.n
+}
+
+type maeAccumulator struct {
+	sum float64
+	n   int
+}
+
+func (a *maeAccumulator) Add(pred, label float64) {
+	a.sum += math.Abs(pred - label)
+	a.n++
+}
+
+func (a *maeAccumulator) Value() float64 {
+	return a.sum / float64(a.n)
+}
+
+func (a *maeAccumulator) Merge(other metricAccumulator) {
+	b := other.(*maeAccumulator)
+	a.sum += b.sum
+	a.n += b.n
+}
+
+type brierAccumulator struct {
+	sum float64
+	n   int
+}
+
+func (a *brierAccumulator) Add(pred, label float64) {
+	var y float64
+	if label > 0 {
+		y = 1
+	}
+	a.sum += (pred - y) * (pred - y)
+	a.n++
+}
+
+func (a *brierAccumulator) Value() float64 {
+	return a.sum / float64(a.n)
+}
+
+func (a *brierAccumulator) Merge(other metricAccumulator) {
+	b := other.(*brierAccumulator)
+	a.sum += b.sum
+	a.n += b.n
+}
+
+// eceAccumulator sums the predictions and the positives in equal-width bins
+// of the prediction. ECE is the weighted mean of the gaps between the mean
+// prediction and the positive rate of each bin.
+type eceAccumulator struct {
+	preds     []float64
+	positives []float64
+	counts    []float64
+}
+
+func newECEAccumulator(bins int) *eceAccumulator {
+	return &eceAccumulator{
+		preds:     make([]float64, bins),
+		positives: make([]float64, bins),
+		counts:    make([]float64, bins),
+	}
+}
+
+func (a *eceAccumulator) Add(pred, label float64) {
+	bins := len(a.counts)
+	bin := int(pred * float64(bins))
+	if bin < 0 {
+		bin = 0
+	} else if bin >= bins {
+		bin = bins - 1
+	}
+	a.preds[bin] += pred
+	if label > 0 {
+		a.positives[bin]++
+	}
+	a.counts[bin]++
+}
+
+func (a *eceAccumulator) Value() float64 {
+	var n, gaps float64
+	for i := range a.counts {
+		n += a.counts[i]
+		gaps += math.Abs(a.preds[i] - a.positives[i])
+	}
+	// each bin weighs count/n times |mean pred - rate|, which is the gap of
+	// the sums over n.
+	return gaps / n
+}
+
+func (a *eceAccumulator) Merge(other metricAccumulator) {
+	b := other.(*eceAccumulator)
+	for i := range a.counts {
+		a.preds[i] += b.preds[i]
+		a.positives[i] += b.positives[i]
+		a.counts[i] += b.counts[i]
+	}
+}
+
+// aucHistogramBins is the number of the bins of the approximate AUC. Only the
+// pairs in the same bin are miscounted, each bin being 1.5e-5 wide.
+const aucHistogramBins = 1 << 16
+
+// aucAccumulator computes the ROC AUC exactly while it holds up to limit
+// examples. Beyond that, it switches to counting the positives and the
+// negatives in a fixed number of bins of the prediction, which takes constant
+// memory and counts the pairs in the same bin as ties.
+type aucAccumulator struct {
+	limit  int
+	preds  []float64
+	labels []float64
+
+	// pos and neg are the histograms, or nil while it's exact.
+	pos []float64
+	neg []float64
+}
+
+func newAUCAccumulator(limit int) *aucAccumulator {
+	a := &aucAccumulator{limit: limit}
+	if limit == 0 {
+		a.pos = make([]float64, aucHistogramBins)
+		a.neg = make([]float64, aucHistogramBins)
+	}
+	return a
+}
+
+func (a *aucAccumulator) Add(pred, label float64) {
+	if a.pos != nil {
+		a.addToHistogram(pred, label)
+		return
+	}
+	a.preds = append(a.preds, pred)
+	a.labels = append(a.labels, label)
+	if len(a.preds) > a.limit {
+		a.toHistogram()
+	}
+}
+
+// toHistogram switches to the histograms, adding the exact examples to them.
+func (a *aucAccumulator) toHistogram() {
+	a.pos = make([]float64, aucHistogramBins)
+	a.neg = make([]float64, aucHistogramBins)
+	for i := range a.preds {
+		a.addToHistogram(a.preds[i], a.labels[i])
+	}
+	a.preds, a.labels = nil, nil
+}
+
+func (a *aucAccumulator) addToHistogram(pred, label float64) {
+	bin := int(pred * aucHistogramBins)
+	if bin < 0 {
+		bin = 0
+	} else if bin >= aucHistogramBins {
+		bin = aucHistogramBins - 1
+	}
+	if label > 0 {
+		a.pos[bin]++
+	} else {
+		a.neg[bin]++
+	}
+}
+
+func (a *aucAccumulator) Value() float64 {
+	if a.pos == nil {
+		return rocAUC(a.preds, a.labels)
+	}
+	var nPos, nNeg, area float64
+	for i := range a.pos {
+		// positives rank above the negatives in the lower bins, and tie
+		// with the ones in the same bin.
+		area += a.pos[i] * (nNeg + a.neg[i]/2)
+		nPos += a.pos[i]
+		nNeg += a.neg[i]
+	}
+	if nPos == 0 || nNeg == 0 {
+		return math.NaN()
+	}
+	return area / (nPos * nNeg)
+}
+
+// Err returns errSingleClass if the examples are all positive or all
+// negative.
+func (a *aucAccumulator) Err() error {
+	var nPos, nNeg float64
+	if a.pos == nil {
+		for _, label := range a.labels {
+			if label > 0 {
+				nPos++
+			} else {
+				nNeg++
+			}
+		}
+	} else {
+		for i := range a.pos {
+			nPos += a.pos[i]
+			nNeg += a.neg[i]
+		}
+	}
+	if nPos == 0 || nNeg == 0 {
+		return errSingleClass
+	}
+	return nil
+}
+
+// Merge adds the examples of other, staying exact while the examples of both
+// are within the limit, so that the value is the one of a single accumulator
+// of all the examples.
+func (a *aucAccumulator) Merge(other metricAccumulator) {
+	b := other.(*aucAccumulator)
+	if a.pos == nil && b.pos == nil && len(a.preds)+len(b.preds) <= a.limit {
+		a.preds = append(a.preds, b.preds...)
+		a.labels = append(a.labels, b.labels...)
+		return
+	}
+	if a.pos == nil {
+		a.toHistogram()
+	}
+	if b.pos == nil {
+		for i := range b.preds {
+			a.addToHistogram(b.preds[i], b.labels[i])
+		}
+		return
+	}
+	for i := range a.pos {
+		a.pos[i] += b.pos[i]
+		a.neg[i] += b.neg[i]
+	}
+}
+
+// rocAUC computes the area under the ROC curve by the rank statistic,
+// giving tied predictions their average rank.
+func rocAUC(preds, labels []float64) float64 {
+	idx := make([]int, len(preds))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(i, j int) bool {
+		return preds[idx[i]] < preds[idx[j]]
+	})
+
+	var rankSum, nPos float64
+	for i := 0; i < len(idx); {
+		j := i
+		for j < len(idx) && preds[idx[j]] == preds[idx[i]] {
+			j++
+		}
+		rank := float64(i+j+1) / 2 // average 1-origin rank of the ties
+		for k := i; k < j; k++ {
+			if labels[idx[k]] > 0 {
+				rankSum += rank
+				nPos++
+			}
+		}
+		i = j
+	}
+	nNeg := float64(len(preds)) - nPos
+	if nPos == 0 || nNeg == 0 {
+		return math.NaN()
+	}
+	return (rankSum - nPos*(nPos+1)/2) / (nPos * nNeg)
+}
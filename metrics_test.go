@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestCheckLabelsOfGradedRelevance(t *testing.T) {
+	f, err := ioutil.TempFile("", "goptuna-libffm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("3 0:1:1\n0 0:2:1\n1 0:3:1\n2 0:4:1\n")
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for metric, ok := range map[string]bool{
+		metricPrecisionAtK: true,
+		metricRecallAtK:    true,
+		metricRMSE:         true,
+		metricLogLoss:      false,
+		metricAUC:          false,
+		metricECE:          false,
+	} {
+		if err := checkLabels(f.Name(), metric); (err == nil) != ok {
+			t.Errorf("checkLabels(%s) = %v", metric, err)
+		}
+	}
+
+	acc := newRankingAccumulator(2, false)
+	for i, pred := range []float64{0.9, 0.8, 0.1, 0.7} {
+		acc.Add(pred, []float64{3, 0, 1, 2}[i])
+	}
+	// the top 2 are the labels 3 and 0.
+	if v := acc.Value(); v != 0.5 {
+		t.Errorf("precision@2 of graded labels = %g, want 0.5", v)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/c-bata/goptuna"
+)
+
+// ModelInfo is the header of a libffm model file.
+type ModelInfo struct {
+	SizeBytes     int64 `json:"size_bytes"`
+	Features      int   `json:"features"`
+	Fields        int   `json:"fields"`
+	Latent        int   `json:"latent"`
+	Normalization bool  `json:"normalization"`
+	// FieldNames are the names of the fields by -feature-map.
+	FieldNames []string `json:"field_names,omitempty"`
+}
+
+// summarizeModel reads the header of the model written by ffm-train, without
+// reading the weights. libffm writes either a text model starting with lines
+// like "n 123", or a binary one starting with n, m and k as int32 and the
+// normalization as a byte.
+func summarizeModel(path string) (ModelInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ModelInfo{}, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return ModelInfo{}, err
+	}
+
+	r := bufio.NewReader(f)
+	head, err := r.Peek(2)
+	if err != nil {
+		return ModelInfo{}, fmt.Errorf("%s is too short for a model: %s", path, err)
+	}
+	var info ModelInfo
+	if bytes.Equal(head, []byte("n ")) {
+		info, err = readTextModelHeader(r)
+	} else {
+		info, err = readBinaryModelHeader(r)
+	}
+	if err != nil {
+		return ModelInfo{}, fmt.Errorf("invalid model header of %s: %s", path, err)
+	}
+	info.SizeBytes = st.Size()
+	return info, nil
+}
+
+func readTextModelHeader(r *bufio.Reader) (ModelInfo, error) {
+	var info ModelInfo
+	for _, key := range []string{"n", "m", "k", "normalization"} {
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return ModelInfo{}, err
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != key {
+			return ModelInfo{}, fmt.Errorf("expected %q, got %q", key, strings.TrimSpace(line))
+		}
+		v, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return ModelInfo{}, err
+		}
+		switch key {
+		case "n":
+			info.Features = v
+		case "m":
+			info.Fields = v
+		case "k":
+			info.Latent = v
+		case "normalization":
+			info.Normalization = v != 0
+		}
+	}
+	return info, nil
+}
+
+func readBinaryModelHeader(r io.Reader) (ModelInfo, error) {
+	var header struct {
+		N, M, K       int32
+		Normalization uint8
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return ModelInfo{}, err
+	}
+	if header.N < 0 || header.M < 0 || header.K <= 0 {
+		return ModelInfo{}, fmt.Errorf("n=%d, m=%d, k=%d", header.N, header.M, header.K)
+	}
+	return ModelInfo{
+		Features:      int(header.N),
+		Fields:        int(header.M),
+		Latent:        int(header.K),
+		Normalization: header.Normalization != 0,
+	}, nil
+}
+
+// reportModel logs the header of the retrained model, and warns if its latent
+// differs from the latent of the trial. With the feature map, the fields are
+// logged by their names.
+func reportModel(path string, trial goptuna.FrozenTrial, features *featureMap) (ModelInfo, error) {
+	info, err := summarizeModel(path)
+	if err != nil {
+		return ModelInfo{}, err
+	}
+	log.Printf("model %s: %d bytes, %d features, %d fields, latent=%d, normalization=%t",
+		path, info.SizeBytes, info.Features, info.Fields, info.Latent, info.Normalization)
+	if features != nil {
+		info.FieldNames = features.FieldNames(info.Fields)
+		log.Printf("model %s: fields %s", path, strings.Join(info.FieldNames, ", "))
+	}
+	if params, err := trialParams(trial); err == nil {
+		if latent, err := intParam(params, "latent"); err == nil && latent != info.Latent {
+			log.Printf("latent of the model differs from trial=%d: %d (trial %d)",
+				trial.Number, info.Latent, latent)
+		}
+	}
+	return info, nil
+}
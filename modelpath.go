@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/c-bata/goptuna"
+)
+
+var (
+	placeholderPattern = regexp.MustCompile(`\{[^{}]*\}`)
+	unsafePathChars    = regexp.MustCompile(`[^A-Za-z0-9.\-]`)
+)
+
+// validateModelPath returns an error if the template of the model path has
+// placeholders other than {trial} and {value}.
+func validateModelPath(tmpl string) error {
+	for _, p := range placeholderPattern.FindAllString(tmpl, -1) {
+		if p != "{trial}" && p != "{value}" {
+			return fmt.Errorf("unknown placeholder %s in %q: use {trial} or {value}", p, tmpl)
+		}
+	}
+	if strings.Count(tmpl, "{") != strings.Count(tmpl, "}") {
+		return fmt.Errorf("unbalanced braces in %q", tmpl)
+	}
+	return nil
+}
+
+// expandModelPath replaces {trial} with the number of the trial and {value}
+// with its value, which is sanitized to be safe in a file name.
+func expandModelPath(tmpl string, trial goptuna.FrozenTrial) string {
+	value := strconv.FormatFloat(trial.Value, 'g', 6, 64)
+	value = unsafePathChars.ReplaceAllString(value, "_")
+	return strings.NewReplacer(
+		"{trial}", strconv.Itoa(trial.Number),
+		"{value}", value,
+	).Replace(tmpl)
+}
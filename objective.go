@@ -0,0 +1,454 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/c-bata/goptuna"
+)
+
+// failedValue is the value returned with an error. goptuna fails such a trial
+// without storing the value, and NaN never compares as the best anyway, unlike
+// a sentinel like -1 which would beat any logloss.
+var failedValue = math.NaN()
+
+// latentChoices are the powers of two used when sampling latent on a log scale.
+var latentChoices = []string{"1", "2", "4", "8", "16"}
+
+// suggestLatent samples the number of latent factors. With log2 set, it is
+// sampled as a categorical over powers of two because goptuna's SuggestInt has
+// no log scale; the choices are integer strings so intParam reads them back.
+func suggestLatent(trial goptuna.Trial, log2 bool) (int, error) {
+	if !log2 {
+		return trial.SuggestInt("latent", 1, 16)
+	}
+	v, err := trial.SuggestCategorical("latent", latentChoices)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(v)
+}
+
+// intParam reads an integer parameter regardless of whether it was sampled by
+// SuggestInt, SuggestCategorical or decoded from JSON.
+func intParam(params map[string]interface{}, name string) (int, error) {
+	switch v := params[name].(type) {
+	case int:
+		return v, nil
+	case float64:
+		return int(v), nil
+	case string:
+		return strconv.Atoi(v)
+	default:
+		return 0, fmt.Errorf("param %q is not an integer: %v", name, params[name])
+	}
+}
+
+// effectiveParamsAttrKey is a user attr of the params which ffm-train ran
+// with when they differ from the sampled ones.
+const effectiveParamsAttrKey = "effective_params"
+
+// effectiveParams reads lambda, eta and latent from the params.
+func effectiveParams(params map[string]interface{}) (float64, float64, int, error) {
+	lmd, ok := params["lambda"].(float64)
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("param \"lambda\" is not a float: %v", params["lambda"])
+	}
+	eta, ok := params["eta"].(float64)
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("param \"eta\" is not a float: %v", params["eta"])
+	}
+	latent, err := intParam(params, "latent")
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return lmd, eta, latent, nil
+}
+
+// trialParams returns the params which ffm-train ran with in the trial.
+func trialParams(trial goptuna.FrozenTrial) (map[string]interface{}, error) {
+	s, ok := trial.UserAttrs[effectiveParamsAttrKey]
+	if !ok {
+		return trial.Params, nil
+	}
+	var params map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &params); err != nil {
+		return nil, fmt.Errorf("invalid %s of trial %d: %s", effectiveParamsAttrKey, trial.Number, err)
+	}
+	return params, nil
+}
+
+// violatedConstraint returns the index of the first constraint which the params
+// violate, or -1 if they satisfy all of them.
+func violatedConstraint(constraints []func(map[string]interface{}) bool, params map[string]interface{}) int {
+	for i, c := range constraints {
+		if !c(params) {
+			return i
+		}
+	}
+	return -1
+}
+
+// shellSafeChars are the characters which need no quoting in a shell.
+const shellSafeChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./=:,+@%"
+
+// shellJoin quotes the arguments so that the command line can be pasted into
+// a POSIX shell.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		if a != "" && strings.Trim(a, shellSafeChars) == "" {
+			quoted[i] = a
+			continue
+		}
+		quoted[i] = "'" + strings.Replace(a, "'", `'\''`, -1) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+// runner holds the state shared by the trials of a sweep.
+type runner struct {
+	// attrErrors counts the user attrs which failed to be stored. It's first
+	// so that it's aligned for the atomic operations on 32-bit platforms.
+	attrErrors int64
+
+	cfg *Config
+	// space is the ranges of the sampled params.
+	space searchSpace
+	// buckets are the ranges of lambda and eta by latent, or nil to sample
+	// them from space.
+	buckets []latentBucket
+	// baseline is the logloss of the constant predictor for -normalize.
+	baseline float64
+	// queue is the sampler of the study to look up re-queued params.
+	queue *queuedSampler
+	// server is the persistent ffm-train process of -warm-pool, or nil to
+	// start ffm-train per trial.
+	server *trainServer
+	// autosave is notified of the finished trials, or nil.
+	autosave *autosaver
+	// summary is notified of the finished trials for -summary-json, or nil.
+	summary *summaryWriter
+	// profiler measures the storage writes for -profile-storage, or nil.
+	profiler *storageProfiler
+	// cmdLog records the commands for -command-log, or nil.
+	cmdLog *commandLog
+	// cache is the evaluations of -cache-dir, or nil.
+	cache *evalCache
+	// failures counts the failed trials by category.
+	failures failureCounter
+	// cores are the cores of -pin-cores, or nil to run ffm-train unpinned.
+	cores *corePool
+	// janitor caps the files of the runs by -max-artifact-bytes, or nil.
+	janitor *artifactJanitor
+	// procs caps the ffm-train processes for -max-procs, or nil.
+	procs *procSemaphore
+	// store keeps the files of the runs for -artifact-store, or nil.
+	store artifactStore
+	// journal appends the finished trials for -journal, or nil.
+	journal *journalWriter
+	// fields are the fields of -select-fields, or nil to train on all.
+	fields []int
+	// rerunSplitSeed is the seed of -random-split of the trial which
+	// -params-from-trial re-runs, or 0 to seed the split by the trial number.
+	rerunSplitSeed int64
+	// budget is the trials left to the workers of the sweep, or nil outside
+	// of a sweep, where a diverged trial isn't retried.
+	budget *trialBudget
+}
+
+// suggestParams samples lambda, eta and latent. With latent buckets, latent
+// is sampled first and lambda and eta are sampled from the ranges of its
+// bucket as the params of the bucket, e.g. "eta_k4".
+func (r *runner) suggestParams(trial goptuna.Trial) (float64, float64, int, error) {
+	if r.buckets == nil {
+		lmd, err := trial.SuggestLogUniform("lambda", r.space.lambdaLow, r.space.lambdaHigh)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		eta, err := trial.SuggestLogUniform("eta", r.space.etaLow, r.space.etaHigh)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		latent, err := suggestLatent(trial, r.cfg.LatentLog2)
+		return lmd, eta, latent, err
+	}
+
+	latent, err := suggestLatent(trial, r.cfg.LatentLog2)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	b := bucketOf(r.buckets, latent)
+	lmd, err := trial.SuggestLogUniform(b.paramName("lambda"), b.space.lambdaLow, b.space.lambdaHigh)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	eta, err := trial.SuggestLogUniform(b.paramName("eta"), b.space.etaLow, b.space.etaHigh)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return lmd, eta, latent, nil
+}
+
+// objective runs the Trainer with the sampled hyperparameters and returns the
+// configured metric.
+func (r *runner) objective(trial goptuna.Trial) (float64, error) {
+	cfg := r.cfg
+	// no param of the trial is sampled after it returns.
+	defer r.queue.Forget(trial.ID)
+	lmd, eta, latent, err := r.suggestParams(trial)
+	if err != nil {
+		return failedValue, err
+	}
+	// the number names the files of the runs, so the trial can't go on
+	// without it.
+	number, err := trial.Number()
+	if err != nil {
+		return failedValue, categorize(failureStorage, fmt.Errorf("failed to get the trial number: %s", err))
+	}
+	if err = trial.SetSystemAttr(runIDAttrKey, cfg.RunID); err != nil {
+		return failedValue, err
+	}
+	var trainSeed int
+	if cfg.TrainSeedFlag != "" {
+		// recorded as a param so that re-running the params reuses the seed.
+		seed := int(trialSeed(cfg.Seed, number) & 0x7fffffff)
+		if r.queue != nil {
+			if xr, ok := r.queue.Param(trial.Study, trial.ID, "train_seed"); ok {
+				if v, ok := xr.(int); ok {
+					seed = v
+				}
+			}
+		}
+		trainSeed, err = trial.SuggestInt("train_seed", seed, seed)
+		if err != nil {
+			return failedValue, err
+		}
+	}
+	params := map[string]interface{}{
+		"lambda": lmd,
+		"eta":    eta,
+		"latent": latent,
+	}
+	if cfg.EtaDecayFlag != "" {
+		if params[etaDecayParam], err = trial.SuggestUniform(etaDecayParam, cfg.EtaDecayMin, cfg.EtaDecayMax); err != nil {
+			return failedValue, err
+		}
+	}
+	for _, field := range r.fields {
+		name := fieldParamName(field)
+		if params[name], err = trial.SuggestCategorical(name, fieldChoices); err != nil {
+			return failedValue, err
+		}
+	}
+	if cfg.TransformParams != nil {
+		params = cfg.TransformParams(params)
+		if lmd, eta, latent, err = effectiveParams(params); err != nil {
+			return failedValue, fmt.Errorf("invalid params by TransformParams: %s", err)
+		}
+	}
+	// the bucketed params are recorded as lambda and eta too, so that the
+	// trials are compared and retrained regardless of the buckets.
+	if cfg.TransformParams != nil || r.buckets != nil {
+		r.storeEffectiveParams(trial, params)
+	}
+	if i := violatedConstraint(cfg.Constraints, params); i >= 0 {
+		r.setUserAttr(trial, "pruned_by", fmt.Sprintf("constraint %d", i))
+		return failedValue, goptuna.ErrTrialPruned
+	}
+	if cfg.MaxMemoryMB > 0 {
+		mb, ok, err := predictMemoryMB(trial.Study, latent)
+		if err != nil {
+			return failedValue, err
+		}
+		if ok && mb > cfg.MaxMemoryMB {
+			r.setUserAttr(trial, "pruned_by", fmt.Sprintf("memory: predicted %.0f MB for latent=%d", mb, latent))
+			return failedValue, goptuna.ErrTrialPruned
+		}
+	}
+	data, err := selectedData(cfg, params)
+	if err == errNoFields {
+		r.setUserAttr(trial, "pruned_by", "no field selected")
+		return failedValue, goptuna.ErrTrialPruned
+	}
+	if err != nil {
+		return failedValue, err
+	}
+	if cfg.RandomSplit > 0 {
+		seed := splitSeed(cfg.DataSeed, number)
+		if r.rerunSplitSeed != 0 {
+			seed = r.rerunSplitSeed
+		}
+		r.setUserAttr(trial, "split_seed", strconv.FormatInt(seed, 10))
+		if data, err = randomSplit(cfg.TrainPath, cfg.RandomSplit, seed, number); err != nil {
+			return failedValue, fmt.Errorf("failed to split the data: %s", err)
+		}
+		defer removeSplit(data)
+	}
+	// a copy, so that train_seed isn't one of the effective params.
+	trainParams := make(map[string]interface{}, len(params)+1)
+	for name, v := range params {
+		trainParams[name] = v
+	}
+	if cfg.TrainSeedFlag != "" {
+		trainParams["train_seed"] = trainSeed
+	}
+	paths := TrainPaths{Trial: number, Train: data.train, Valid: data.valid}
+
+	ctx := trial.GetContext()
+	if cfg.TrialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.TrialTimeout)
+		defer cancel()
+	}
+	trainer := r.trainer()
+	value, attrs, err := trainer.Train(ctx, trainParams, paths)
+	// stored even with an error, e.g. the command of a failed trial.
+	for key, v := range attrs {
+		r.setUserAttr(trial, key, v)
+	}
+	if err == errDiverged {
+		r.setUserAttr(trial, divergedAttrKey, "true")
+		if r.retryDiverged(trial, number, eta) {
+			return failedValue, goptuna.ErrTrialPruned
+		}
+		if cfg.PenalizeDivergence {
+			return divergedValue(objectiveDirection(cfg)), nil
+		}
+		return failedValue, errDiverged
+	}
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		if errorCategory(err) != failureTimeout {
+			err = categorize(failureTimeout, fmt.Errorf("timed out after -trial-timeout=%s: %s", cfg.TrialTimeout, err))
+		}
+		if cfg.TimeoutAction == timeoutActionPrune {
+			return r.pruneTimedOut(trial, value, attrs, latent, err)
+		}
+	}
+	if err != nil {
+		return failedValue, err
+	}
+	if !isPlausible(cfg, value) {
+		r.setUserAttr(trial, "implausible_value", fmt.Sprintf("%g", value))
+		return failedValue, categorize(failureImplausible, fmt.Errorf("%s=%g is out of the plausible range [%g, %g]",
+			cfg.Metric, value, cfg.PlausibleMin, cfg.PlausibleMax))
+	}
+	return r.objectiveValue(trial, value, latent), nil
+}
+
+// retryDiverged enqueues the params of the diverged trial with the halved
+// eta as a new trial, up to -divergence-retry times in a row, and returns
+// whether it did. The diverged trial is pruned then, so that the params of
+// each trial are the ones which its value was evaluated with.
+func (r *runner) retryDiverged(trial goptuna.Trial, number int, eta float64) bool {
+	cfg := r.cfg
+	// the eta of TransformParams isn't the param, so it isn't retried.
+	if cfg.DivergenceRetry == 0 || cfg.TransformParams != nil || r.budget == nil || eta/2 < r.space.etaLow {
+		return false
+	}
+	frozen, err := trial.Study.Storage.GetTrial(trial.ID)
+	if err != nil {
+		log.Printf("WARNING: failed to get the params of trial %d to retry: %s", number, err)
+		return false
+	}
+	var retries int
+	if s, ok := frozen.SystemAttrs[divergenceRetriesAttrKey]; ok {
+		if retries, err = strconv.Atoi(s); err != nil {
+			log.Printf("WARNING: invalid %s of trial %d: %q", divergenceRetriesAttrKey, number, s)
+			return false
+		}
+	}
+	if retries >= cfg.DivergenceRetry {
+		return false
+	}
+	params := make(map[string]interface{}, len(frozen.Params))
+	for name, v := range frozen.Params {
+		params[name] = v
+	}
+	params["eta"] = eta / 2
+	r.queue.Enqueue(params, map[string]string{
+		divergenceRetriesAttrKey: strconv.Itoa(retries + 1),
+		divergenceRetryOfAttrKey: strconv.Itoa(number),
+	})
+	// the retry is run besides -n-trials.
+	r.budget.Add(1)
+	log.Printf("trial %d diverged, retrying with eta=%g (%d/%d)", number, eta/2, retries+1, cfg.DivergenceRetry)
+	return true
+}
+
+// objectiveValue returns the objective value of the metric of the trial by
+// -normalize, -latent-penalty and Config.ValueTransform.
+func (r *runner) objectiveValue(trial goptuna.Trial, value float64, latent int) float64 {
+	cfg := r.cfg
+	if cfg.Normalize {
+		r.setUserAttr(trial, "raw_value", fmt.Sprintf("%f", value))
+		value = (r.baseline - value) / r.baseline
+		r.setUserAttr(trial, "normalized_value", fmt.Sprintf("%f", value))
+	}
+	if cfg.LatentPenalty != 0 {
+		penalty := cfg.LatentPenalty * float64(latent)
+		r.setUserAttr(trial, "latent_penalty", fmt.Sprintf("%f", penalty))
+		if objectiveDirection(cfg) == goptuna.StudyDirectionMaximize {
+			value -= penalty
+		} else {
+			value += penalty
+		}
+	}
+	if cfg.ValueTransform != nil {
+		r.setUserAttr(trial, "untransformed_value", fmt.Sprintf("%f", value))
+		value = cfg.ValueTransform(value)
+	}
+	return value
+}
+
+// storeEffectiveParams stores the params which ffm-train ran with as the
+// effective_params attr of the trial.
+func (r *runner) storeEffectiveParams(trial goptuna.Trial, params map[string]interface{}) {
+	b, err := json.Marshal(params)
+	if err != nil {
+		atomic.AddInt64(&r.attrErrors, 1)
+		log.Printf("WARNING: failed to encode the %s of trial %d: %s", effectiveParamsAttrKey, trial.ID, err)
+		return
+	}
+	r.setUserAttr(trial, effectiveParamsAttrKey, string(b))
+}
+
+// setUserAttr stores the user attr of the trial. The attrs are diagnostics,
+// so a failure is logged and counted rather than failing the trial.
+func (r *runner) setUserAttr(trial goptuna.Trial, key, value string) {
+	if err := trial.SetUserAttr(key, value); err != nil {
+		atomic.AddInt64(&r.attrErrors, 1)
+		log.Printf("WARNING: failed to store the user attr %s of trial %d: %s", key, trial.ID, err)
+	}
+}
+
+// isPlausible reports whether the metric is within -plausible-min and
+// -plausible-max, which are disabled when both are 0. A value outside likely
+// means that a different field was parsed, e.g. after the JSON meta of
+// libffm changed.
+func isPlausible(cfg *Config, value float64) bool {
+	if cfg.PlausibleMin == 0 && cfg.PlausibleMax == 0 {
+		return true
+	}
+	return cfg.PlausibleMin <= value && value <= cfg.PlausibleMax
+}
+
+// meanStd returns the mean and the population standard deviation.
+func meanStd(values []float64) (float64, float64) {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	var sq float64
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(sq / float64(len(values)))
+}
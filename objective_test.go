@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/c-bata/goptuna"
+)
+
+// stubDivergingTrain is an ffm-train which diverges for an eta above 0.001.
+const stubDivergingTrain = `#!/bin/sh
+if [ "$1" = "--version" ]; then
+	echo "libffm stub"
+	exit 0
+fi
+while [ $# -gt 0 ]; do
+	case "$1" in
+	-r) eta=$2; shift ;;
+	--json-meta) meta=$2; shift ;;
+	esac
+	shift
+done
+if awk "BEGIN { exit !($eta > 0.001) }"; then
+	echo '{"best_iteration": 3, "best_va_loss": nan}' > "$meta"
+else
+	echo '{"best_iteration": 3, "best_va_loss": 0.5}' > "$meta"
+fi
+`
+
+func TestDivergenceRetryStoresTheEtaOfTheValue(t *testing.T) {
+	defer inSweepDir(t)()
+	if err := ioutil.WriteFile("ffm-train", []byte(stubDivergingTrain), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := sweepConfig(t, "-dsn", "study.db", "-n-trials", "4", "-concurrency", "1", "-divergence-retry", "12")
+	if err := RunStudy(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	study, db, err := loadExistingStudy(cfg.DSN, cfg.StudyName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	trials, err := study.GetTrials()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var completed, retried int
+	for _, trial := range trials {
+		if _, ok := trial.SystemAttrs[divergenceRetryOfAttrKey]; ok {
+			retried++
+		}
+		switch trial.State {
+		case goptuna.TrialStateComplete:
+			completed++
+		case goptuna.TrialStatePruned:
+			if trial.UserAttrs[divergedAttrKey] != "true" {
+				t.Errorf("trial %d is pruned without diverging", trial.Number)
+			}
+			continue
+		default:
+			t.Errorf("trial %d is %s", trial.Number, trial.State)
+			continue
+		}
+		eta, ok := trial.Params["eta"].(float64)
+		if !ok {
+			t.Fatalf("trial %d has no eta: %v", trial.Number, trial.Params)
+		}
+		args := strings.Fields(trial.UserAttrs["command"])
+		var ran string
+		for i := range args[:len(args)-1] {
+			if args[i] == "-r" {
+				ran = args[i+1]
+			}
+		}
+		if want := formatFloatArg(eta, cfg.ParamPrecision); ran != want {
+			t.Errorf("trial %d stored eta=%s, but its value is of ffm-train with -r %s", trial.Number, want, ran)
+		}
+	}
+	if completed != 4 {
+		t.Errorf("%d trials completed, want the 4 of -n-trials besides the retries", completed)
+	}
+	if retried == 0 {
+		t.Error("no trial diverged, so the retries aren't tested")
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/c-bata/goptuna"
+)
+
+// runOnce evaluates the params of -param by the objective of the study, and
+// prints the trial as a line of -export. The trial is kept in memory, so
+// neither the storage nor the sampler is involved.
+func runOnce(ctx context.Context, cfg *Config) error {
+	r, err := newOnceRunner(cfg)
+	if err != nil {
+		return err
+	}
+	params, err := onceParams(cfg.OnceParams, r.distributions(), cfg.TrainSeedFlag != "")
+	if err != nil {
+		return err
+	}
+	_, err = r.evaluateOnce(ctx, params)
+	return err
+}
+
+// newOnceRunner returns the runner of a trial in memory by runOnce.
+func newOnceRunner(cfg *Config) (*runner, error) {
+	checkValueTransform(cfg)
+	if err := checkTrainer(cfg); err != nil {
+		return nil, err
+	}
+	r := &runner{cfg: cfg, space: defaultSearchSpace}
+	var err error
+	if cfg.AutoScale {
+		summary, err := summarizeData(cfg.TrainPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to summarize the training data: %s", err)
+		}
+		r.space = autoScaleSearchSpace(summary.Examples)
+	}
+	if cfg.SelectFields {
+		if r.fields, err = scanFields(cfg.TrainPath); err != nil {
+			return nil, fmt.Errorf("failed to scan the fields: %s", err)
+		}
+	}
+	if cfg.Normalize {
+		if r.baseline, err = baselineLogLoss(evalDataPath(cfg)); err != nil {
+			return nil, fmt.Errorf("failed to compute the baseline logloss: %s", err)
+		}
+	}
+	return r, nil
+}
+
+// evaluateOnce runs the objective with the params by a trial in memory, and
+// prints the trial as a line of -export. A pruned trial isn't an error.
+func (r *runner) evaluateOnce(ctx context.Context, params map[string]interface{}) (goptuna.FrozenTrial, error) {
+	cfg := r.cfg
+	r.queue = newQueuedSampler(goptuna.NewRandomSearchSampler())
+	r.queue.Enqueue(params, nil)
+	study, err := goptuna.CreateStudy(
+		"once",
+		goptuna.StudyOptionStorage(goptuna.NewInMemoryStorage()),
+		goptuna.StudyOptionSampler(r.queue),
+		goptuna.StudyOptionSetDirection(objectiveDirection(cfg)),
+		goptuna.StudyOptionLogger(nil),
+	)
+	if err != nil {
+		return goptuna.FrozenTrial{}, err
+	}
+	study.WithContext(ctx)
+	objErr := study.Optimize(r.objective, 1)
+	trials, err := study.GetTrials()
+	if err != nil {
+		return goptuna.FrozenTrial{}, err
+	}
+	if len(trials) != 1 {
+		return goptuna.FrozenTrial{}, objErr
+	}
+	if err = json.NewEncoder(os.Stdout).Encode(newExportedTrial(trials[0], exportAttrsOmitted)); err != nil {
+		return goptuna.FrozenTrial{}, err
+	}
+	if objErr == goptuna.ErrTrialPruned {
+		objErr = nil
+	}
+	return trials[0], objErr
+}
+
+// onceParams converts the values of -param to the params of the
+// distributions. Every param of the distributions must be given, and
+// train_seed may be given if the seed is set.
+func onceParams(values map[string]string, dists map[string]interface{}, seed bool) (map[string]interface{}, error) {
+	params := make(map[string]interface{}, len(values))
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "train_seed" && seed {
+			v, err := strconv.Atoi(values[name])
+			if err != nil {
+				return nil, fmt.Errorf("-param %s: %s is not an integer", name, values[name])
+			}
+			params[name] = v
+			continue
+		}
+		d, ok := dists[name]
+		if !ok {
+			return nil, fmt.Errorf("-param %s is not a param of the search space", name)
+		}
+		var xr interface{} = values[name]
+		switch d.(type) {
+		case goptuna.UniformDistribution, goptuna.LogUniformDistribution:
+			v, err := strconv.ParseFloat(values[name], 64)
+			if err != nil {
+				return nil, fmt.Errorf("-param %s: %s is not a number", name, values[name])
+			}
+			xr = v
+		case goptuna.IntUniformDistribution:
+			v, err := strconv.Atoi(values[name])
+			if err != nil {
+				return nil, fmt.Errorf("-param %s: %s is not an integer", name, values[name])
+			}
+			xr = v
+		}
+		if _, err := toInternalRepr(d, xr); err != nil {
+			return nil, fmt.Errorf("-param %s: %s", name, err)
+		}
+		params[name] = xr
+	}
+	var missing []string
+	for name := range dists {
+		if _, ok := params[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("-param is missing for %s", strings.Join(missing, ", "))
+	}
+	return params, nil
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/c-bata/goptuna"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// optunaSchemaVersion and optunaAlembicVersion are the schema of the RDB
+	// storage of Optuna 3.2 and later, which optuna-dashboard opens.
+	optunaSchemaVersion  = 12
+	optunaAlembicVersion = "v3.2.0.a"
+	optunaLibraryVersion = "3.2.0"
+	// optunaDatetimeFormat is how SQLAlchemy stores DATETIME in SQLite.
+	optunaDatetimeFormat = "2006-01-02 15:04:05.000000"
+)
+
+// optunaSchema creates the tables of Optuna's RDB storage.
+var optunaSchema = []string{
+	`CREATE TABLE studies (
+		study_id INTEGER NOT NULL PRIMARY KEY,
+		study_name VARCHAR(512) NOT NULL UNIQUE)`,
+	`CREATE TABLE study_directions (
+		study_direction_id INTEGER NOT NULL PRIMARY KEY,
+		direction VARCHAR(8) NOT NULL,
+		study_id INTEGER NOT NULL REFERENCES studies (study_id),
+		objective INTEGER NOT NULL,
+		UNIQUE (study_id, objective))`,
+	`CREATE TABLE study_user_attributes (
+		study_user_attribute_id INTEGER NOT NULL PRIMARY KEY,
+		study_id INTEGER REFERENCES studies (study_id),
+		key VARCHAR(512),
+		value_json TEXT,
+		UNIQUE (study_id, key))`,
+	`CREATE TABLE study_system_attributes (
+		study_system_attribute_id INTEGER NOT NULL PRIMARY KEY,
+		study_id INTEGER REFERENCES studies (study_id),
+		key VARCHAR(512),
+		value_json TEXT,
+		UNIQUE (study_id, key))`,
+	`CREATE TABLE trials (
+		trial_id INTEGER NOT NULL PRIMARY KEY,
+		number INTEGER,
+		study_id INTEGER REFERENCES studies (study_id),
+		state VARCHAR(8) NOT NULL,
+		datetime_start DATETIME,
+		datetime_complete DATETIME)`,
+	`CREATE TABLE trial_user_attributes (
+		trial_user_attribute_id INTEGER NOT NULL PRIMARY KEY,
+		trial_id INTEGER REFERENCES trials (trial_id),
+		key VARCHAR(512),
+		value_json TEXT,
+		UNIQUE (trial_id, key))`,
+	`CREATE TABLE trial_system_attributes (
+		trial_system_attribute_id INTEGER NOT NULL PRIMARY KEY,
+		trial_id INTEGER REFERENCES trials (trial_id),
+		key VARCHAR(512),
+		value_json TEXT,
+		UNIQUE (trial_id, key))`,
+	`CREATE TABLE trial_params (
+		param_id INTEGER NOT NULL PRIMARY KEY,
+		trial_id INTEGER REFERENCES trials (trial_id),
+		param_name VARCHAR(512),
+		param_value FLOAT,
+		distribution_json TEXT,
+		UNIQUE (trial_id, param_name))`,
+	`CREATE TABLE trial_values (
+		trial_value_id INTEGER NOT NULL PRIMARY KEY,
+		trial_id INTEGER NOT NULL REFERENCES trials (trial_id),
+		objective INTEGER NOT NULL,
+		value FLOAT,
+		value_type VARCHAR(7) NOT NULL,
+		UNIQUE (trial_id, objective))`,
+	`CREATE TABLE trial_intermediate_values (
+		trial_intermediate_value_id INTEGER NOT NULL PRIMARY KEY,
+		trial_id INTEGER NOT NULL REFERENCES trials (trial_id),
+		step INTEGER NOT NULL,
+		intermediate_value FLOAT,
+		intermediate_value_type VARCHAR(7) NOT NULL,
+		UNIQUE (trial_id, step))`,
+	`CREATE TABLE trial_heartbeats (
+		trial_heartbeat_id INTEGER NOT NULL PRIMARY KEY,
+		trial_id INTEGER NOT NULL UNIQUE REFERENCES trials (trial_id),
+		heartbeat DATETIME NOT NULL)`,
+	`CREATE TABLE version_info (
+		version_info_id INTEGER NOT NULL PRIMARY KEY CHECK (version_info_id = 1),
+		schema_version INTEGER,
+		library_version VARCHAR(256))`,
+	`CREATE TABLE alembic_version (
+		version_num VARCHAR(32) NOT NULL PRIMARY KEY)`,
+}
+
+// optunaTrialStates maps goptuna's trial states to Optuna's.
+var optunaTrialStates = map[goptuna.TrialState]string{
+	goptuna.TrialStateRunning:  "RUNNING",
+	goptuna.TrialStateComplete: "COMPLETE",
+	goptuna.TrialStatePruned:   "PRUNED",
+	goptuna.TrialStateFail:     "FAIL",
+}
+
+// exportOptuna writes the study into a new SQLite database of Optuna's
+// schema, so that it can be opened by Optuna and optuna-dashboard. It's a
+// one-way export; the database is written to a temporary file and renamed to
+// path after it's complete.
+func exportOptuna(cfg *Config) error {
+	if _, err := os.Stat(cfg.ExportOptuna); err == nil {
+		return fmt.Errorf("%s already exists", cfg.ExportOptuna)
+	}
+	study, src, err := loadExistingStudy(cfg.DSN, cfg.StudyName)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmpPath := filepath.Join(filepath.Dir(cfg.ExportOptuna), "."+filepath.Base(cfg.ExportOptuna)+".tmp")
+	os.Remove(tmpPath)
+	dst, err := openDB(tmpPath)
+	if err != nil {
+		return err
+	}
+	err = writeOptunaStudy(dst, cfg.StudyName, study)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, cfg.ExportOptuna)
+}
+
+func writeOptunaStudy(db *gorm.DB, studyName string, study *goptuna.Study) error {
+	trials, err := study.GetTrials()
+	if err != nil {
+		return err
+	}
+	sort.Slice(trials, func(i, j int) bool {
+		return trials[i].Number < trials[j].Number
+	})
+	userAttrs, err := study.GetUserAttrs()
+	if err != nil {
+		return err
+	}
+	systemAttrs, err := study.GetSystemAttrs()
+	if err != nil {
+		return err
+	}
+
+	tx := db.Begin()
+	if err = writeOptunaTables(tx, studyName, study.Direction(), userAttrs, systemAttrs, trials); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func writeOptunaTables(
+	tx *gorm.DB,
+	studyName string,
+	direction goptuna.StudyDirection,
+	userAttrs, systemAttrs map[string]string,
+	trials []goptuna.FrozenTrial,
+) error {
+	exec := func(query string, args ...interface{}) error {
+		return tx.Exec(query, args...).Error
+	}
+	for _, q := range optunaSchema {
+		if err := exec(q); err != nil {
+			return err
+		}
+	}
+	if err := exec("INSERT INTO version_info VALUES (1, ?, ?)", optunaSchemaVersion, optunaLibraryVersion); err != nil {
+		return err
+	}
+	if err := exec("INSERT INTO alembic_version VALUES (?)", optunaAlembicVersion); err != nil {
+		return err
+	}
+
+	const studyID = 1
+	if err := exec("INSERT INTO studies VALUES (?, ?)", studyID, studyName); err != nil {
+		return err
+	}
+	optunaDirection := "MINIMIZE"
+	if direction == goptuna.StudyDirectionMaximize {
+		optunaDirection = "MAXIMIZE"
+	}
+	if err := exec("INSERT INTO study_directions VALUES (1, ?, ?, 0)", optunaDirection, studyID); err != nil {
+		return err
+	}
+	if err := insertOptunaAttrs(exec, "study_user_attributes", studyID, userAttrs); err != nil {
+		return err
+	}
+	if err := insertOptunaAttrs(exec, "study_system_attributes", studyID, systemAttrs); err != nil {
+		return err
+	}
+
+	for i, t := range trials {
+		trialID := i + 1
+		state := optunaTrialStates[t.State]
+		complete := t.State == goptuna.TrialStateComplete
+		if complete && math.IsNaN(t.Value) {
+			// Optuna fails the trials which return NaN.
+			state, complete = "FAIL", false
+		}
+		// goptuna numbers the trials from 1 while Optuna does from 0.
+		if err := exec("INSERT INTO trials VALUES (?, ?, ?, ?, ?, ?)",
+			trialID, i, studyID, state,
+			optunaDatetime(t.DatetimeStart), optunaDatetime(t.DatetimeComplete)); err != nil {
+			return err
+		}
+		if complete {
+			value, valueType := optunaValue(t.Value)
+			if err := exec("INSERT INTO trial_values (trial_id, objective, value, value_type) VALUES (?, 0, ?, ?)",
+				trialID, value, valueType); err != nil {
+				return err
+			}
+		}
+		for step, v := range t.IntermediateValues {
+			value, valueType := optunaValue(v)
+			if err := exec("INSERT INTO trial_intermediate_values "+
+				"(trial_id, step, intermediate_value, intermediate_value_type) VALUES (?, ?, ?, ?)",
+				trialID, step, value, valueType); err != nil {
+				return err
+			}
+		}
+		for name, xr := range t.Params {
+			ir, dist, err := optunaParam(t.Distributions[name], xr)
+			if err != nil {
+				return fmt.Errorf("param %q of trial %d: %s", name, t.Number, err)
+			}
+			if err = exec("INSERT INTO trial_params (trial_id, param_name, param_value, distribution_json) VALUES (?, ?, ?, ?)",
+				trialID, name, ir, dist); err != nil {
+				return err
+			}
+		}
+		if err := insertOptunaAttrs(exec, "trial_user_attributes", trialID, t.UserAttrs); err != nil {
+			return err
+		}
+		if err := insertOptunaAttrs(exec, "trial_system_attributes", trialID, t.SystemAttrs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertOptunaAttrs inserts the attrs, which Optuna stores as JSON values.
+func insertOptunaAttrs(exec func(string, ...interface{}) error, table string, id int, attrs map[string]string) error {
+	column := "study_id"
+	if table == "trial_user_attributes" || table == "trial_system_attributes" {
+		column = "trial_id"
+	}
+	for k, v := range attrs {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if err = exec(fmt.Sprintf("INSERT INTO %s (%s, key, value_json) VALUES (?, ?, ?)", table, column),
+			id, k, string(b)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func optunaDatetime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.Local().Format(optunaDatetimeFormat)
+}
+
+// optunaValue returns the value and its type, because Optuna stores the
+// infinities as NULL with the type.
+func optunaValue(v float64) (interface{}, string) {
+	switch {
+	case math.IsInf(v, 1):
+		return nil, "INF_POS"
+	case math.IsInf(v, -1):
+		return nil, "INF_NEG"
+	}
+	return v, "FINITE"
+}
+
+// optunaParam returns the internal representation of the param and the JSON
+// of its distribution in Optuna's format.
+func optunaParam(distribution interface{}, xr interface{}) (float64, string, error) {
+	ir, err := goptuna.ToInternalRepresentation(distribution, xr)
+	if err != nil {
+		return 0, "", err
+	}
+	var name string
+	var attrs map[string]interface{}
+	switch d := distribution.(type) {
+	case goptuna.UniformDistribution:
+		name = "FloatDistribution"
+		attrs = map[string]interface{}{"low": d.Low, "high": d.High, "step": nil, "log": false}
+	case goptuna.LogUniformDistribution:
+		name = "FloatDistribution"
+		attrs = map[string]interface{}{"low": d.Low, "high": d.High, "step": nil, "log": true}
+	case goptuna.DiscreteUniformDistribution:
+		name = "FloatDistribution"
+		attrs = map[string]interface{}{"low": d.Low, "high": d.High, "step": d.Q, "log": false}
+	case goptuna.IntUniformDistribution:
+		name = "IntDistribution"
+		attrs = map[string]interface{}{"low": d.Low, "high": d.High, "step": 1, "log": false}
+	case goptuna.CategoricalDistribution:
+		name = "CategoricalDistribution"
+		attrs = map[string]interface{}{"choices": d.Choices}
+	default:
+		return 0, "", goptuna.ErrUnknownDistribution
+	}
+	b, err := json.Marshal(map[string]interface{}{"name": name, "attributes": attrs})
+	return ir, string(b), err
+}
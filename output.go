@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io/ioutil"
+	"strings"
+)
+
+// gzipOutputPrefix marks the stdout and stderr attrs which are gzipped and
+// base64-encoded by -compress-output.
+const gzipOutputPrefix = "gzip+base64:"
+
+// encodeOutput returns the value of the stdout or stderr attr.
+func encodeOutput(output string, compress bool) string {
+	if !compress {
+		return output
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	// writes to bytes.Buffer never fail.
+	_, _ = zw.Write([]byte(output))
+	_ = zw.Close()
+	return gzipOutputPrefix + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// decodeOutput returns the output stored in the stdout or stderr attr,
+// decompressing it if it is encoded by -compress-output.
+func decodeOutput(value string) (string, error) {
+	if !strings.HasPrefix(value, gzipOutputPrefix) {
+		return value, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(value[len(gzipOutputPrefix):])
+	if err != nil {
+		return "", err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+	out, err := ioutil.ReadAll(zr)
+	return string(out), err
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"unicode"
+)
+
+// predictionBatchLines is the number of the examples of a batch of
+// streamPredictionsParallel.
+const predictionBatchLines = 4096
+
+// lineBatch is the non-empty lines of a file for a batch, in a single buffer.
+type lineBatch struct {
+	buf  []byte
+	ends []int
+	// numbers are the line numbers, for the errors.
+	numbers []int
+}
+
+func (b *lineBatch) add(line []byte, number int) {
+	b.buf = append(b.buf, line...)
+	b.ends = append(b.ends, len(b.buf))
+	b.numbers = append(b.numbers, number)
+}
+
+func (b *lineBatch) line(i int) []byte {
+	start := 0
+	if i > 0 {
+		start = b.ends[i-1]
+	}
+	return b.buf[start:b.ends[i]]
+}
+
+// predictionBatch is the lines of the predictions and of the validation data
+// of the same examples.
+type predictionBatch struct {
+	preds  lineBatch
+	labels lineBatch
+}
+
+// nonEmptyLines scans the non-empty lines of a file with their numbers.
+type nonEmptyLines struct {
+	scanner *bufio.Scanner
+	number  int
+}
+
+func newNonEmptyLines(f *os.File) *nonEmptyLines {
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return &nonEmptyLines{scanner: scanner}
+}
+
+// Next returns the next non-empty line, valid until the next call, or false
+// at the end.
+func (s *nonEmptyLines) Next() ([]byte, bool) {
+	for s.scanner.Scan() {
+		s.number++
+		if len(bytes.TrimSpace(s.scanner.Bytes())) > 0 {
+			return s.scanner.Bytes(), true
+		}
+	}
+	return nil, false
+}
+
+// Count returns the number of the remaining non-empty lines.
+func (s *nonEmptyLines) Count() int {
+	var n int
+	for _, ok := s.Next(); ok; _, ok = s.Next() {
+		n++
+	}
+	return n
+}
+
+// firstField parses the first field of the line as a number, like
+// columnReader.
+func firstField(line []byte) (float64, error) {
+	line = bytes.TrimLeftFunc(line, unicode.IsSpace)
+	if i := bytes.IndexFunc(line, unicode.IsSpace); i >= 0 {
+		line = line[:i]
+	}
+	return strconv.ParseFloat(string(line), 64)
+}
+
+// streamPredictionsParallel is streamPredictions with the examples
+// accumulated by the workers: the lines are read in batches, which the
+// workers parse into their own accumulators of newAccs, and the accumulators
+// of the workers are merged into the returned ones. The accumulators must be
+// mergeableAccumulator.
+func streamPredictionsParallel(predPath, validPath string, workers int, newAccs func() []metricAccumulator) ([]metricAccumulator, error) {
+	pf, err := os.Open(predPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read predictions: %s", err)
+	}
+	defer pf.Close()
+	vf, err := os.Open(validPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read labels: %s", err)
+	}
+	defer vf.Close()
+
+	batches := make(chan *predictionBatch, workers)
+	accs := make([][]metricAccumulator, workers)
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for w := range accs {
+		accs[w] = newAccs()
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for b := range batches {
+				// the rest is drained after an error, so that the reader
+				// isn't blocked.
+				if errs[w] == nil {
+					errs[w] = accumulateBatch(b, accs[w])
+				}
+			}
+		}(w)
+	}
+
+	preds, labels := newNonEmptyLines(pf), newNonEmptyLines(vf)
+	var nPreds, nLabels int
+	b := &predictionBatch{}
+	for {
+		pred, predOK := preds.Next()
+		label, labelOK := labels.Next()
+		if predOK {
+			nPreds++
+		}
+		if labelOK {
+			nLabels++
+		}
+		if !predOK || !labelOK {
+			// count the rest to report the mismatch.
+			nPreds += preds.Count()
+			nLabels += labels.Count()
+			break
+		}
+		b.preds.add(pred, preds.number)
+		b.labels.add(label, labels.number)
+		if len(b.preds.ends) == predictionBatchLines {
+			batches <- b
+			b = &predictionBatch{}
+		}
+	}
+	if len(b.preds.ends) > 0 {
+		batches <- b
+	}
+	close(batches)
+	wg.Wait()
+
+	if err = preds.scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read predictions: %s", err)
+	}
+	if err = labels.scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read labels: %s", err)
+	}
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	if nPreds != nLabels {
+		return nil, fmt.Errorf(
+			"ffm-predict wrote %d predictions for %d validation examples",
+			nPreds, nLabels)
+	}
+	if nPreds == 0 {
+		return nil, errors.New("no predictions")
+	}
+	for _, worker := range accs[1:] {
+		for i, a := range worker {
+			accs[0][i].(mergeableAccumulator).Merge(a)
+		}
+	}
+	return accs[0], nil
+}
+
+// accumulateBatch parses the examples of the batch and adds them to accs. An
+// error is of the first malformed line of the batch, which may not be the
+// first of the file.
+func accumulateBatch(b *predictionBatch, accs []metricAccumulator) error {
+	for i := range b.preds.ends {
+		pred, err := firstField(b.preds.line(i))
+		if err != nil {
+			return fmt.Errorf("failed to read predictions: line %d: %s", b.preds.numbers[i], err)
+		}
+		label, err := firstField(b.labels.line(i))
+		if err != nil {
+			return fmt.Errorf("failed to read labels: line %d: %s", b.labels.numbers[i], err)
+		}
+		for _, a := range accs {
+			a.Add(pred, label)
+		}
+	}
+	return nil
+}
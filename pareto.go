@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strconv"
+
+	"github.com/c-bata/goptuna"
+)
+
+// paretoTrial is a trial on the Pareto front of validation loss and latent.
+type paretoTrial struct {
+	Number int                    `json:"number"`
+	VALoss float64                `json:"va_loss"`
+	Latent int                    `json:"latent"`
+	Params map[string]interface{} `json:"params"`
+	RunID  string                 `json:"run_id,omitempty"`
+}
+
+// vaLoss returns the raw validation loss of the completed trial, which
+// differs from its value when -latent-penalty is set.
+func vaLoss(trial goptuna.FrozenTrial) float64 {
+	if s, ok := trial.UserAttrs["va_loss"]; ok {
+		if v, err := strconv.ParseFloat(s, 64); err == nil {
+			return v
+		}
+	}
+	return trial.Value
+}
+
+// paretoFront returns the completed trials which no other trial dominates in
+// both validation loss and latent, sorted by latent. goptuna optimizes only a
+// single objective, so the front is computed from the trials afterwards.
+func paretoFront(trials []goptuna.FrozenTrial) []paretoTrial {
+	candidates := make([]paretoTrial, 0, len(trials))
+	for _, t := range trials {
+		if t.State != goptuna.TrialStateComplete || t.UserAttrs[divergedAttrKey] != "" {
+			continue
+		}
+		latent, err := intParam(t.Params, "latent")
+		if err != nil {
+			continue
+		}
+		candidates = append(candidates, paretoTrial{
+			Number: t.Number,
+			VALoss: vaLoss(t),
+			Latent: latent,
+			Params: t.Params,
+			RunID:  t.SystemAttrs[runIDAttrKey],
+		})
+	}
+
+	front := make([]paretoTrial, 0, 8)
+	for i := range candidates {
+		dominated := false
+		for j := range candidates {
+			a, b := candidates[j], candidates[i]
+			if a.VALoss <= b.VALoss && a.Latent <= b.Latent &&
+				(a.VALoss < b.VALoss || a.Latent < b.Latent) {
+				dominated = true
+				break
+			}
+		}
+		if !dominated {
+			front = append(front, candidates[i])
+		}
+	}
+	sort.Slice(front, func(i, j int) bool {
+		if front[i].Latent != front[j].Latent {
+			return front[i].Latent < front[j].Latent
+		}
+		return front[i].Number < front[j].Number
+	})
+	return front
+}
+
+// writeParetoJSON writes the Pareto front to path.
+func writeParetoJSON(path string, front []paretoTrial) error {
+	b, err := json.MarshalIndent(front, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// reportParetoFront logs the Pareto front of the study and writes it to
+// cfg.ParetoJSON if set.
+func reportParetoFront(cfg *Config, study *goptuna.Study) error {
+	trials, err := study.GetTrials()
+	if err != nil {
+		return err
+	}
+	front := paretoFront(trials)
+	for _, t := range front {
+		log.Printf("Pareto-optimal trial=%d (va_loss=%f, latent=%d)", t.Number, t.VALoss, t.Latent)
+	}
+	if cfg.ParetoJSON == "" {
+		return nil
+	}
+	return writeParetoJSON(cfg.ParetoJSON, front)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// pauseCheckInterval is the interval of checking the file of -control-file.
+const pauseCheckInterval = time.Second
+
+// pauseGate pauses the sweep while the file of -control-file exists: a
+// watcher polls the file, and the workers block before starting new trials
+// while it's there. The running trials finish as usual.
+type pauseGate struct {
+	path string
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	paused bool
+	done   bool
+}
+
+func newPauseGate(ctx context.Context, path string) *pauseGate {
+	g := &pauseGate{path: path}
+	g.cond = sync.NewCond(&g.mu)
+	g.check()
+	go g.watch(ctx)
+	return g
+}
+
+func (g *pauseGate) watch(ctx context.Context) {
+	ticker := time.NewTicker(pauseCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			g.mu.Lock()
+			g.done = true
+			g.mu.Unlock()
+			g.cond.Broadcast()
+			return
+		case <-ticker.C:
+		}
+		g.check()
+	}
+}
+
+// check updates the state by the existence of the file.
+func (g *pauseGate) check() {
+	_, err := os.Stat(g.path)
+	paused := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		log.Print("failed to check the control file:", err)
+		return
+	}
+	g.mu.Lock()
+	changed := paused != g.paused
+	g.paused = paused
+	g.mu.Unlock()
+	if !changed {
+		return
+	}
+	if paused {
+		log.Printf("%s exists: pausing the sweep after the running trials", g.path)
+	} else {
+		log.Printf("%s is removed: resuming the sweep", g.path)
+	}
+	g.cond.Broadcast()
+}
+
+// Wait blocks while the sweep is paused. It returns false if ctx is done.
+func (g *pauseGate) Wait() bool {
+	if g == nil {
+		return true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for !g.done && g.paused {
+		g.cond.Wait()
+	}
+	return !g.done
+}
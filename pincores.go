@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// corePool hands out the cores of -pin-cores, split into a set per worker.
+// A trial takes a free set for its ffm-train run, so that no two runs share
+// a core and a run isn't migrated to the cores of another.
+type corePool struct {
+	bin  string
+	sets chan string
+}
+
+// newCorePool splits the cores into a set per worker like "0,1". It returns
+// nil, which runs ffm-train unpinned, if CPU affinity isn't supported. The
+// cores left over by the split are not used.
+func newCorePool(spec string, workers int) (*corePool, error) {
+	cores, err := parseCPUList(spec)
+	if err != nil {
+		return nil, err
+	}
+	if len(cores) < workers {
+		return nil, fmt.Errorf("-pin-cores has %d cores for %d workers", len(cores), workers)
+	}
+	bin, err := affinityCommand()
+	if err != nil {
+		log.Printf("-pin-cores is ignored: %s", err)
+		return nil, nil
+	}
+	p := &corePool{bin: bin, sets: make(chan string, workers)}
+	per := len(cores) / workers
+	for i := 0; i < workers; i++ {
+		set := make([]string, per)
+		for j := range set {
+			set[j] = strconv.Itoa(cores[i*per+j])
+		}
+		p.sets <- strings.Join(set, ",")
+	}
+	return p, nil
+}
+
+// Acquire takes a set of cores, waiting for one to be released if needed.
+func (p *corePool) Acquire() string {
+	if p == nil {
+		return ""
+	}
+	return <-p.sets
+}
+
+// Release returns the set of cores taken by Acquire.
+func (p *corePool) Release(set string) {
+	if p == nil {
+		return
+	}
+	p.sets <- set
+}
+
+// Pin prepends the command which runs the command line on the cores.
+func (p *corePool) Pin(set, bin string, args []string) (string, []string) {
+	if p == nil {
+		return bin, args
+	}
+	pinned := make([]string, 0, len(args)+3)
+	pinned = append(pinned, "-c", set, bin)
+	pinned = append(pinned, args...)
+	return p.bin, pinned
+}
+
+// parseCPUList parses a list of cores like "0-3,8,10-11" into the sorted
+// cores.
+func parseCPUList(spec string) ([]int, error) {
+	seen := make(map[int]bool, 8)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		low, high := part, part
+		if i := strings.IndexByte(part, '-'); i >= 0 {
+			low, high = part[:i], part[i+1:]
+		}
+		l, err := strconv.Atoi(low)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cores %q", part)
+		}
+		h, err := strconv.Atoi(high)
+		if err != nil || l < 0 || h < l {
+			return nil, fmt.Errorf("invalid cores %q", part)
+		}
+		for c := l; c <= h; c++ {
+			seen[c] = true
+		}
+	}
+	cores := make([]int, 0, len(seen))
+	for c := range seen {
+		cores = append(cores, c)
+	}
+	sort.Ints(cores)
+	return cores, nil
+}
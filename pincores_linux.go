@@ -0,0 +1,12 @@
+//go:build linux
+// +build linux
+
+package main
+
+import "os/exec"
+
+// affinityCommand returns the path of taskset, which sets the CPU affinity
+// of the command it runs.
+func affinityCommand() (string, error) {
+	return exec.LookPath("taskset")
+}
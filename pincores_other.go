@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+// affinityCommand returns an error because the CPU affinity is only set on
+// Linux.
+func affinityCommand() (string, error) {
+	return "", errors.New("CPU affinity is not supported on this platform")
+}
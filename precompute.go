@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// binPath returns the path where ffm-train --on-disk stores the binary
+// version of the text data: "<basename>.bin" in the working directory.
+func binPath(txtPath string) string {
+	return filepath.Base(txtPath) + ".bin"
+}
+
+// precomputeBin converts the train and validation data into libffm's binary
+// format by running one iteration of ffm-train --on-disk. ffm-train reuses
+// the binary files as long as the text files are unchanged, so trials started
+// afterwards only read them and can share them safely.
+func precomputeBin(ctx context.Context, cfg *Config, cmdLog *commandLog) error {
+	model, err := ioutil.TempFile("", "ffm-precompute-*.model")
+	if err != nil {
+		return err
+	}
+	model.Close()
+	defer os.Remove(model.Name())
+
+	args := []string{
+		"--on-disk",
+		"-t", "1",
+		"-p", cfg.ValidPath,
+		cfg.TrainPath,
+		model.Name(),
+	}
+	bin, args := wrapCommand(cfg, cfg.TrainBin, args)
+	if err = cmdLog.Log(-1, bin, args); err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, bin, args...)
+	cmd.Env = trainEnv(cfg)
+	out, err := cmd.CombinedOutput()
+	if err = checkExitCode(cfg, err); err != nil {
+		return fmt.Errorf("%s: %s", err, out)
+	}
+
+	for _, p := range []string{cfg.TrainPath, cfg.ValidPath} {
+		if _, err = os.Stat(binPath(p)); err != nil {
+			return fmt.Errorf("binary data is not written: %s", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// jsonMetaFields are the fields of the JSON meta which the objective reads.
+var jsonMetaFields = []string{"best_iteration", "best_va_loss"}
+
+// probeData is a tiny dataset in libffm format to probe ffm-train.
+const probeData = `1 0:0:1 1:2:1
+0 0:1:1 1:3:1
+1 0:0:1 1:3:1
+0 0:1:1 1:2:1
+`
+
+// probeJSONMeta runs a tiny training with the same flags as the trials and
+// checks that ffm-train writes the JSON meta with the fields of
+// jsonMetaFields. libffm forks differ in the schema of --json-meta, so the
+// mismatch is reported before any trial fails with it.
+func probeJSONMeta(ctx context.Context, cfg *Config, cmdLog *commandLog) error {
+	dir, err := ioutil.TempDir("", "ffm-probe-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	dataPath := filepath.Join(dir, "data.txt")
+	if err = ioutil.WriteFile(dataPath, []byte(probeData), 0644); err != nil {
+		return err
+	}
+	metaPath := filepath.Join(dir, "meta.json")
+
+	args := []string{
+		"-p", dataPath,
+		"--auto-stop", "--auto-stop-threshold", "3",
+		"-t", "2",
+		"--json-meta", metaPath,
+	}
+	if cfg.TrainSeedFlag != "" {
+		args = append(args, cfg.TrainSeedFlag, "1")
+	}
+	args = append(args, dataPath, filepath.Join(dir, "model"))
+	bin, args := wrapCommand(cfg, cfg.TrainBin, args)
+	if err = cmdLog.Log(-1, bin, args); err != nil {
+		return err
+	}
+	out := &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, bin, args...)
+	cmd.Env = trainEnv(cfg)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err = checkExitCode(cfg, cmd.Run()); err != nil {
+		return fmt.Errorf("%s: %s", err, out)
+	}
+
+	b, err := ioutil.ReadFile(metaPath)
+	if err != nil {
+		return fmt.Errorf("ffm-train wrote no JSON meta (does it support --json-meta?): %s: %s", err, out)
+	}
+	var meta map[string]json.RawMessage
+	if err = json.Unmarshal(nonFiniteJSON.ReplaceAll(b, []byte(`$1"$2"`)), &meta); err != nil {
+		return fmt.Errorf("ffm-train wrote an invalid JSON meta: %s: %s", err, b)
+	}
+	var missing []string
+	for _, f := range jsonMetaFields {
+		if _, ok := meta[f]; !ok {
+			missing = append(missing, f)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("the JSON meta of %s lacks %s: %s", cfg.TrainBin, strings.Join(missing, ", "), b)
+	}
+	return nil
+}
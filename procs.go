@@ -0,0 +1,41 @@
+package main
+
+import "context"
+
+// procSemaphore caps the ffm-train processes of -max-procs running at once,
+// regardless of the workers of -concurrency, so that the workers waiting
+// for the cache or the storage don't count against the processes.
+type procSemaphore struct {
+	slots chan struct{}
+}
+
+// newProcSemaphore returns the semaphore of n processes, or nil, which
+// doesn't limit them, if n isn't positive.
+func newProcSemaphore(n int) *procSemaphore {
+	if n <= 0 {
+		return nil
+	}
+	return &procSemaphore{slots: make(chan struct{}, n)}
+}
+
+// Acquire takes a slot, waiting for one to be released or for ctx to be
+// done, whose error it returns.
+func (s *procSemaphore) Acquire(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release returns the slot taken by Acquire.
+func (s *procSemaphore) Release() {
+	if s == nil {
+		return
+	}
+	<-s.slots
+}
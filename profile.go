@@ -0,0 +1,139 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/c-bata/goptuna"
+)
+
+var _ goptuna.Storage = &storageProfiler{}
+
+// storageProfiler measures the latency of the trial writes of the storage,
+// to tell whether the database limits the throughput of the sweep. A trial
+// whose writes take longer than the average ffm-train run is logged.
+type storageProfiler struct {
+	goptuna.Storage
+
+	mu       sync.Mutex
+	writes   int
+	total    time.Duration
+	max      time.Duration
+	perTrial map[int]time.Duration
+	trains   int
+	trainSum time.Duration
+}
+
+func newStorageProfiler(storage goptuna.Storage) *storageProfiler {
+	return &storageProfiler{
+		Storage:  storage,
+		perTrial: make(map[int]time.Duration, 8),
+	}
+}
+
+func (p *storageProfiler) record(trialID int, start time.Time) {
+	d := time.Since(start)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.writes++
+	p.total += d
+	if d > p.max {
+		p.max = d
+	}
+	p.perTrial[trialID] += d
+}
+
+// ObserveTrain records the duration of an ffm-train run.
+func (p *storageProfiler) ObserveTrain(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.trains++
+	p.trainSum += d
+}
+
+// trialFinished logs the write latency of the trial if it exceeds the
+// average ffm-train run.
+func (p *storageProfiler) trialFinished(trialID int) {
+	p.mu.Lock()
+	d := p.perTrial[trialID]
+	delete(p.perTrial, trialID)
+	var avg time.Duration
+	if p.trains > 0 {
+		avg = p.trainSum / time.Duration(p.trains)
+	}
+	p.mu.Unlock()
+	if avg > 0 && d > avg {
+		log.Printf("storage writes of trialID=%d took %s, longer than the average ffm-train run %s", trialID, d, avg)
+	}
+}
+
+// Report logs the aggregated write latency.
+func (p *storageProfiler) Report() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.writes == 0 {
+		log.Print("storage profile: no writes")
+		return
+	}
+	var train time.Duration
+	if p.trains > 0 {
+		train = p.trainSum / time.Duration(p.trains)
+	}
+	log.Printf("storage profile: %d writes, total=%s, mean=%s, max=%s (ffm-train mean=%s over %d runs)",
+		p.writes, p.total, p.total/time.Duration(p.writes), p.max, train, p.trains)
+}
+
+// CreateNewTrialID creates a new trial and returns its ID.
+func (p *storageProfiler) CreateNewTrialID(studyID int) (int, error) {
+	start := time.Now()
+	id, err := p.Storage.CreateNewTrialID(studyID)
+	p.record(id, start)
+	return id, err
+}
+
+// SetTrialValue sets the value of the trial.
+func (p *storageProfiler) SetTrialValue(trialID int, value float64) error {
+	defer p.record(trialID, time.Now())
+	return p.Storage.SetTrialValue(trialID, value)
+}
+
+// SetTrialIntermediateValue sets the intermediate value of the trial.
+func (p *storageProfiler) SetTrialIntermediateValue(trialID int, step int, value float64) error {
+	defer p.record(trialID, time.Now())
+	return p.Storage.SetTrialIntermediateValue(trialID, step, value)
+}
+
+// SetTrialParam sets the sampled param of the trial.
+func (p *storageProfiler) SetTrialParam(
+	trialID int,
+	paramName string,
+	paramValueInternal float64,
+	distribution interface{},
+) error {
+	defer p.record(trialID, time.Now())
+	return p.Storage.SetTrialParam(trialID, paramName, paramValueInternal, distribution)
+}
+
+// SetTrialState sets the state of the trial.
+func (p *storageProfiler) SetTrialState(trialID int, state goptuna.TrialState) error {
+	start := time.Now()
+	err := p.Storage.SetTrialState(trialID, state)
+	p.record(trialID, start)
+	if state.IsFinished() {
+		p.trialFinished(trialID)
+	}
+	return err
+}
+
+// SetTrialUserAttr sets the user attr of the trial.
+func (p *storageProfiler) SetTrialUserAttr(trialID int, key string, value string) error {
+	defer p.record(trialID, time.Now())
+	return p.Storage.SetTrialUserAttr(trialID, key, value)
+}
+
+// SetTrialSystemAttr sets the system attr of the trial.
+func (p *storageProfiler) SetTrialSystemAttr(trialID int, key string, value string) error {
+	defer p.record(trialID, time.Now())
+	return p.Storage.SetTrialSystemAttr(trialID, key, value)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// groupedAccumulator is a metricAccumulator which computes the metric per
+// group of the examples, like the query or the user of a ranking.
+type groupedAccumulator interface {
+	metricAccumulator
+	AddGrouped(group string, pred, label float64)
+}
+
+// metricNeedsGroups reports whether the metric needs the groups of
+// -group-file.
+func metricNeedsGroups(metric string) bool {
+	return metric == metricPrecisionAtK || metric == metricRecallAtK
+}
+
+// rankedExample is an example of a group of rankingAccumulator.
+type rankedExample struct {
+	pred     float64
+	positive bool
+}
+
+// rankingAccumulator computes precision@K or recall@K: the examples of each
+// group are ranked by the prediction, and the positives in the top K are
+// counted against K, or against all the positives of the group for recall.
+// The metric is the mean over the groups, skipping the groups without
+// positives.
+type rankingAccumulator struct {
+	k      int
+	recall bool
+	groups map[string][]rankedExample
+}
+
+func newRankingAccumulator(k int, recall bool) *rankingAccumulator {
+	return &rankingAccumulator{k: k, recall: recall, groups: make(map[string][]rankedExample)}
+}
+
+// Add adds the example to a single group, for the callers without groups.
+func (a *rankingAccumulator) Add(pred, label float64) {
+	a.AddGrouped("", pred, label)
+}
+
+func (a *rankingAccumulator) AddGrouped(group string, pred, label float64) {
+	a.groups[group] = append(a.groups[group], rankedExample{pred: pred, positive: label > 0})
+}
+
+func (a *rankingAccumulator) Value() float64 {
+	var sum float64
+	var n int
+	for _, examples := range a.groups {
+		var positives int
+		for _, e := range examples {
+			if e.positive {
+				positives++
+			}
+		}
+		if positives == 0 {
+			continue
+		}
+		// stable, so that the ties are ranked as in the data.
+		sort.SliceStable(examples, func(i, j int) bool {
+			return examples[i].pred > examples[j].pred
+		})
+		var hits int
+		for i := 0; i < a.k && i < len(examples); i++ {
+			if examples[i].positive {
+				hits++
+			}
+		}
+		if a.recall {
+			sum += float64(hits) / float64(positives)
+		} else {
+			sum += float64(hits) / float64(a.k)
+		}
+		n++
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
+
+// groupReader reads the groups of -group-file, the first field of each
+// non-empty line, which are in the order of the validation examples.
+type groupReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func newGroupReader(r io.Reader) *groupReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return &groupReader{scanner: scanner}
+}
+
+// Next returns the group of the next example, or false at the end.
+func (g *groupReader) Next() (string, bool, error) {
+	for g.scanner.Scan() {
+		g.line++
+		fields := strings.Fields(g.scanner.Text())
+		if len(fields) > 0 {
+			return fields[0], true, nil
+		}
+	}
+	if err := g.scanner.Err(); err != nil {
+		return "", false, fmt.Errorf("line %d: %s", g.line+1, err)
+	}
+	return "", false, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/c-bata/goptuna"
+)
+
+// reevaluation is the metric of a top trial re-evaluated by -reeval-top-k.
+type reevaluation struct {
+	Number int `json:"number"`
+	// Value is the objective value of the trial during the study.
+	Value float64 `json:"value"`
+	// Mean and Std are of the metric over the re-evaluation runs.
+	Mean float64 `json:"mean"`
+	Std  float64 `json:"std"`
+	Runs int     `json:"runs"`
+}
+
+// reevaluateTop re-runs each of the cfg.ReevalTopK best trials
+// cfg.ReevalRepeats times with new seeds, and returns the trial with the best
+// mean of the metric and the re-evaluations, the best first. The best value
+// of a noisy study is likely a lucky run, and the extra runs are spent only
+// on the finalists. The trials whose re-evaluation fails are left out.
+func (r *runner) reevaluateTop(ctx context.Context, study *goptuna.Study) (goptuna.FrozenTrial, []reevaluation, error) {
+	cfg := r.cfg
+	trials, err := topTrials(study, bestFilter(cfg), cfg.TieBreak, cfg.ReevalTopK)
+	if err != nil {
+		return goptuna.FrozenTrial{}, nil, err
+	}
+	maximize := metricDirection(cfg.Metric) == goptuna.StudyDirectionMaximize
+	byNumber := make(map[int]goptuna.FrozenTrial, len(trials))
+	reevals := make([]reevaluation, 0, len(trials))
+	for _, t := range trials {
+		e, err := r.reevaluate(ctx, t)
+		if err != nil {
+			if ctx.Err() != nil {
+				return goptuna.FrozenTrial{}, nil, ctx.Err()
+			}
+			log.Printf("failed to re-evaluate trial=%d: %s", t.Number, err)
+			continue
+		}
+		log.Printf("re-evaluated trial=%d: %s=%f (std %f) over %d runs, value=%f in the study",
+			t.Number, cfg.Metric, e.Mean, e.Std, e.Runs, t.Value)
+		byNumber[t.Number] = t
+		reevals = append(reevals, e)
+	}
+	if len(reevals) == 0 {
+		return goptuna.FrozenTrial{}, nil, errors.New("no trial is re-evaluated")
+	}
+	sort.SliceStable(reevals, func(i, j int) bool {
+		if maximize {
+			return reevals[i].Mean > reevals[j].Mean
+		}
+		return reevals[i].Mean < reevals[j].Mean
+	})
+	return byNumber[reevals[0].Number], reevals, nil
+}
+
+// reevaluate runs ffm-train with the params of the trial cfg.ReevalRepeats
+// times. The seeds follow the ones of the trial's -repeats, so that none of
+// the runs of the study is repeated.
+func (r *runner) reevaluate(ctx context.Context, trial goptuna.FrozenTrial) (reevaluation, error) {
+	cfg := r.cfg
+	params, err := trialParams(trial)
+	if err != nil {
+		return reevaluation{}, err
+	}
+	lmd, eta, latent, err := effectiveParams(params)
+	if err != nil {
+		return reevaluation{}, err
+	}
+	schedule, err := scheduleArgs(cfg, params)
+	if err != nil {
+		return reevaluation{}, err
+	}
+	data, err := trialData(cfg, trial)
+	if err != nil {
+		return reevaluation{}, err
+	}
+	seed, err := intParam(trial.Params, "train_seed")
+	if err != nil {
+		return reevaluation{}, err
+	}
+
+	values := make([]float64, cfg.ReevalRepeats)
+	for i := range values {
+		name := fmt.Sprintf("%d-reeval-%d", trial.Number, i)
+		run := r.trainRun(trial.Number, name, lmd, eta, latent, seed+cfg.Repeats+i, schedule, data)
+		e, err := r.evaluate(ctx, run)
+		if err != nil {
+			return reevaluation{}, err
+		}
+		values[i] = e.value
+	}
+	mean, std := meanStd(values)
+	return reevaluation{
+		Number: trial.Number,
+		Value:  trial.Value,
+		Mean:   mean,
+		Std:    std,
+		Runs:   len(values),
+	}, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/c-bata/goptuna"
+)
+
+// rerunTrial evaluates the params of trial -params-from-trial of the study
+// again like -once, and logs the new value next to the recorded one, e.g. to
+// check that a suspicious value is reproduced. The train seed and the split
+// of -random-split are the ones of the trial, so that a deterministic
+// ffm-train gives the same value. The storage isn't written.
+func rerunTrial(ctx context.Context, cfg *Config) error {
+	study, db, err := loadExistingStudy(cfg.DSN, cfg.StudyName)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	trials, err := study.GetTrials()
+	if err != nil {
+		return err
+	}
+	var trial *goptuna.FrozenTrial
+	for i := range trials {
+		if trials[i].Number == cfg.ParamsFromTrial {
+			trial = &trials[i]
+		}
+	}
+	if trial == nil {
+		return fmt.Errorf("trial %d is not in study %s", cfg.ParamsFromTrial, cfg.StudyName)
+	}
+
+	r, err := newOnceRunner(cfg)
+	if err != nil {
+		return err
+	}
+	// the params are parsed as the values of -param, which checks them
+	// against the search space of the flags.
+	values := make(map[string]string, len(trial.Params))
+	for name, xr := range trial.Params {
+		values[name] = fmt.Sprint(xr)
+	}
+	params, err := onceParams(values, r.distributions(), cfg.TrainSeedFlag != "")
+	if err != nil {
+		return fmt.Errorf("the params of trial %d don't match the flags: %s", trial.Number, err)
+	}
+	if cfg.RandomSplit > 0 {
+		if r.rerunSplitSeed, err = strconv.ParseInt(trial.UserAttrs["split_seed"], 10, 64); err != nil {
+			return fmt.Errorf("trial %d has no split_seed of -random-split", trial.Number)
+		}
+	}
+
+	rerun, err := r.evaluateOnce(ctx, params)
+	if err != nil {
+		return err
+	}
+	if trial.State != goptuna.TrialStateComplete || rerun.State != goptuna.TrialStateComplete {
+		log.Printf("trial %d was %s, and the re-run is %s", trial.Number, trial.State, rerun.State)
+		return nil
+	}
+	log.Printf("trial %d: value=%f, re-run value=%f (%+g)", trial.Number, trial.Value, rerun.Value, rerun.Value-trial.Value)
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/c-bata/goptuna"
+)
+
+// resumedAttrKey is a trial system attr marking that the trial's params are
+// already re-queued, so the trial is never resumed twice.
+const resumedAttrKey = "goptuna-libffm:resumed"
+
+// resumeIncompleteTrials re-queues the params of trials which neither
+// completed nor got pruned, e.g. after an ungraceful shutdown. Stale running
+// trials are marked as failed. Don't use it while another process is
+// optimizing the same study because its running trials would be failed too.
+// It returns the number of re-queued trials.
+func resumeIncompleteTrials(study *goptuna.Study, sampler *queuedSampler) (int, error) {
+	trials, err := study.GetTrials()
+	if err != nil {
+		return 0, err
+	}
+	sort.Slice(trials, func(i, j int) bool {
+		return trials[i].Number < trials[j].Number
+	})
+
+	var n int
+	for _, t := range trials {
+		if t.State == goptuna.TrialStateComplete || t.State == goptuna.TrialStatePruned {
+			continue
+		}
+		if _, ok := t.SystemAttrs[resumedAttrKey]; ok {
+			continue
+		}
+
+		if t.State == goptuna.TrialStateRunning {
+			err = study.Storage.SetTrialState(t.ID, goptuna.TrialStateFail)
+			if err != nil {
+				return n, err
+			}
+		}
+		err = study.Storage.SetTrialSystemAttr(t.ID, resumedAttrKey, "true")
+		if err != nil {
+			return n, err
+		}
+		sampler.Enqueue(t.Params, map[string]string{
+			"resumed_from": strconv.Itoa(t.Number),
+		})
+		n++
+	}
+	return n, nil
+}
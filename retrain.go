@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/c-bata/goptuna"
+)
+
+// hyperParamArgs returns the ffm-train arguments of the sampled params.
+func hyperParamArgs(lmd, eta float64, latent, precision int) []string {
+	return []string{
+		"-l", formatFloatArg(lmd, precision),
+		"-r", formatFloatArg(eta, precision),
+		"-k", fmt.Sprintf("%d", latent),
+	}
+}
+
+// formatFloatArg formats a float param with the significant digits. Unlike a
+// fixed number of decimal places, it keeps the small values of the log-uniform
+// ranges, e.g. 1e-6 isn't truncated to 0.
+func formatFloatArg(v float64, precision int) string {
+	return strconv.FormatFloat(v, 'g', precision, 64)
+}
+
+// retrainArgs returns the ffm-train options which retrain the trial: its
+// params and best iteration without early stopping.
+func retrainArgs(cfg *Config, trial goptuna.FrozenTrial) ([]string, error) {
+	params, err := trialParams(trial)
+	if err != nil {
+		return nil, err
+	}
+	lmd, eta, latent, err := effectiveParams(params)
+	if err != nil {
+		return nil, fmt.Errorf("trial %d: %s", trial.Number, err)
+	}
+	iterations := "500"
+	if s, ok := trial.UserAttrs["best_iteration"]; ok {
+		iterations = s
+	}
+
+	schedule, err := scheduleArgs(cfg, params)
+	if err != nil {
+		return nil, fmt.Errorf("trial %d: %s", trial.Number, err)
+	}
+	args := append(hyperParamArgs(lmd, eta, latent, cfg.ParamPrecision), schedule...)
+	args = append(args, "-t", iterations)
+	if cfg.TrainSeedFlag != "" {
+		if seed, err := intParam(trial.Params, "train_seed"); err == nil {
+			args = append(args, cfg.TrainSeedFlag, strconv.Itoa(seed))
+		}
+	}
+	return args, nil
+}
+
+// trialData returns the data which the trial trained on.
+func trialData(cfg *Config, trial goptuna.FrozenTrial) (dataPaths, error) {
+	params, err := trialParams(trial)
+	if err != nil {
+		return dataPaths{}, err
+	}
+	data, err := selectedData(cfg, params)
+	if err != nil {
+		return dataPaths{}, fmt.Errorf("trial %d: %s", trial.Number, err)
+	}
+	return data, nil
+}
+
+// retrain trains a model with the params of the trial on the training data
+// and writes it to modelPath. It runs the best iteration of the trial without
+// early stopping, and replaces modelPath only after the training succeeded.
+func retrain(ctx context.Context, cfg *Config, cmdLog *commandLog, trial goptuna.FrozenTrial, modelPath string) error {
+	args, err := retrainArgs(cfg, trial)
+	if err != nil {
+		return err
+	}
+	data, err := trialData(cfg, trial)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(modelPath), 0755); err != nil {
+		return err
+	}
+	tmpPath := modelPath + ".tmp"
+	args = append(args, data.train, tmpPath)
+
+	bin, args := wrapCommand(cfg, cfg.TrainBin, args)
+	if err = cmdLog.Log(trial.Number, bin, args); err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, bin, args...)
+	cmd.Env = trainEnv(cfg)
+	out, err := cmd.CombinedOutput()
+	if err = checkExitCode(cfg, err); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("%s: %s", err, out)
+	}
+	return os.Rename(tmpPath, modelPath)
+}
+
+// autosaver retrains the best trial in the background every K finished
+// trials, so that a usable model is left even if a long sweep dies. Requests
+// are coalesced and handled one at a time, so autosaves never run
+// concurrently.
+type autosaver struct {
+	cfg      *Config
+	cmdLog   *commandLog
+	features *featureMap
+	study    *goptuna.Study
+	every    int64
+	path     string
+
+	mu       sync.Mutex
+	finished int64
+	request  chan struct{}
+	done     chan struct{}
+	// saved is the number of the trial saved last, or -1.
+	saved int
+}
+
+func newAutosaver(ctx context.Context, cfg *Config, cmdLog *commandLog, features *featureMap, study *goptuna.Study) *autosaver {
+	a := &autosaver{
+		cfg:      cfg,
+		cmdLog:   cmdLog,
+		features: features,
+		study:    study,
+		every:    int64(cfg.AutosaveEvery),
+		path:     cfg.AutosavePath,
+		request:  make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		saved:    -1,
+	}
+	go a.loop(ctx)
+	return a
+}
+
+// TrialFinished counts a finished trial and requests an autosave every K
+// trials.
+func (a *autosaver) TrialFinished() {
+	a.mu.Lock()
+	a.finished++
+	due := a.finished%a.every == 0
+	a.mu.Unlock()
+	if !due {
+		return
+	}
+	select {
+	case a.request <- struct{}{}:
+	default:
+		// an autosave is already pending.
+	}
+}
+
+// Close waits for the running autosave.
+func (a *autosaver) Close() {
+	close(a.request)
+	<-a.done
+}
+
+func (a *autosaver) loop(ctx context.Context) {
+	defer close(a.done)
+	for range a.request {
+		best, err := getBestTrial(a.study, bestFilter(a.cfg), a.cfg.TieBreak)
+		if err != nil {
+			if err != goptuna.ErrNoCompletedTrials {
+				log.Print("failed to get the best trial to autosave:", err)
+			}
+			continue
+		}
+		if best.Number == a.saved {
+			continue
+		}
+		path := expandModelPath(a.path, best)
+		if err = retrain(ctx, a.cfg, a.cmdLog, best, path); err != nil {
+			log.Print("failed to autosave the best model:", err)
+			continue
+		}
+		a.saved = best.Number
+		log.Printf("autosaved the model of trial=%d to %s", best.Number, path)
+		if _, err = reportModel(path, best, a.features); err != nil {
+			log.Print("failed to summarize the autosaved model:", err)
+		}
+	}
+}
@@ -0,0 +1,621 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/c-bata/goptuna"
+	"github.com/c-bata/goptuna/rdb"
+)
+
+// RunStudy runs the trials of the configured study and reports the results.
+// It returns when the trial budget is met or ctx is canceled. Unless
+// cfg.NoSignalHandler is set, SIGINT, SIGTERM and SIGQUIT cancel the trials
+// too.
+func RunStudy(ctx context.Context, cfg *Config) error {
+	v := getVersionInfo(ctx, cfg)
+	log.Printf("versions: goptuna-libffm %s, goptuna %s, libffm %s", v.Build, v.Goptuna, v.Libffm)
+	log.Printf("seeds: sampler %d, data %d", cfg.Seed, cfg.DataSeed)
+	checkValueTransform(cfg)
+	if err := checkTrainer(cfg); err != nil {
+		return err
+	}
+
+	// setup storage
+	if cfg.DBLock != dbLockOff && !cfg.LeaderElection {
+		lock, err := lockDB(cfg.DSN, cfg.DBLock == dbLockWait)
+		if err != nil {
+			return fmt.Errorf("failed to lock the database: %s", err)
+		}
+		defer lock.Close()
+	}
+	db, err := openDB(cfg.DSN)
+	if err != nil {
+		return fmt.Errorf("failed to open db: %s", err)
+	}
+	defer db.Close()
+	if cfg.CheckSchema {
+		if err = checkSchema(db); err != nil {
+			return fmt.Errorf("failed to check the storage schema: %s", err)
+		}
+	}
+	if cfg.CreateIfMissing {
+		rdb.RunAutoMigrate(db)
+	}
+	if cfg.CheckSchema {
+		if err = stampSchema(db); err != nil {
+			return fmt.Errorf("failed to store the schema version: %s", err)
+		}
+	}
+	var storage goptuna.Storage = rdb.NewStorage(db)
+	var profiler *storageProfiler
+	if cfg.ProfileStorage {
+		profiler = newStorageProfiler(storage)
+		storage = profiler
+	}
+
+	var features *featureMap
+	if cfg.FeatureMap != "" {
+		if features, err = loadFeatureMap(cfg.FeatureMap); err != nil {
+			return fmt.Errorf("failed to load the feature map: %s", err)
+		}
+	}
+
+	// -tui shows the logs of the trials below the progress.
+	var logs *logTail
+	var logOutput io.Writer = os.Stdout
+	if cfg.TUI {
+		if isTerminal(os.Stdout) {
+			logs = &logTail{}
+			logOutput = logs
+		} else {
+			log.Print("stdout is not a terminal, so -tui falls back to logging")
+		}
+	}
+
+	// load or create a study
+	sampler := newQueuedSampler(newSeededSampler(cfg.Seed, cfg.SamplerWindow))
+	opts := []goptuna.StudyOption{
+		goptuna.StudyOptionSampler(sampler),
+		goptuna.StudyOptionSetDirection(objectiveDirection(cfg)),
+		goptuna.StudyOptionLogger(&goptuna.StdLogger{
+			Logger: log.New(logOutput, log.Prefix(), log.LstdFlags),
+			Level:  goptuna.LoggerLevelDebug,
+			Color:  true,
+		}),
+	}
+	if cfg.EventSocket != "" {
+		events, err := newEventServer(cfg.EventSocket)
+		if err != nil {
+			return fmt.Errorf("failed to listen on the event socket: %s", err)
+		}
+		// deferred, so that it's closed after the workers stop notifying.
+		defer events.Close()
+		opts = append(opts, goptuna.StudyOptionSetTrialNotifyChannel(events.Trials()))
+	}
+	study, err := loadOrCreateStudy(cfg, storage, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to create study: %s", err)
+	}
+
+	if err = checkStudyObjective(study, cfg); err != nil {
+		return err
+	}
+	if err = storeRunID(study, cfg.RunID); err != nil {
+		return fmt.Errorf("failed to store the run ID: %s", err)
+	}
+	leader := true
+	if cfg.LeaderElection {
+		if leader, err = electLeader(study, cfg.RunID); err != nil {
+			return fmt.Errorf("failed to elect the leader: %s", err)
+		}
+		log.Printf("elected as the leader: %t", leader)
+	}
+	// the worker is marked done after its trials, or when it fails, so that
+	// the leader doesn't wait for it.
+	var markDone func()
+	if cfg.LeaderElection {
+		worker := workerID()
+		if err = registerWorker(study, cfg.RunID, worker); err != nil {
+			return fmt.Errorf("failed to register the worker: %s", err)
+		}
+		var once sync.Once
+		markDone = func() {
+			once.Do(func() {
+				if err := markWorkerDone(study, cfg.RunID, worker); err != nil {
+					log.Print("failed to mark the worker done:", err)
+				}
+			})
+		}
+		defer markDone()
+	}
+	if err = setLabels(study, cfg.Labels); err != nil {
+		return fmt.Errorf("failed to set labels: %s", err)
+	}
+
+	if cfg.DataChecksums || cfg.StrictDataChecksums {
+		if err = checkDataChecksums(study, cfg, cfg.StrictDataChecksums); err != nil {
+			return fmt.Errorf("failed to check the data checksums: %s", err)
+		}
+	}
+
+	var summary dataSummary
+	if cfg.SummarizeData || cfg.AutoScale {
+		summary, err = summarizeData(cfg.TrainPath)
+		if err != nil {
+			return fmt.Errorf("failed to summarize the training data: %s", err)
+		}
+		log.Printf("detected %d fields, %d features, %d examples",
+			summary.Fields, summary.Features, summary.Examples)
+	}
+	if cfg.SummarizeData {
+		if err = storeDataSummary(study, summary); err != nil {
+			return fmt.Errorf("failed to store the data summary: %s", err)
+		}
+	}
+
+	if cfg.CheckLeakage {
+		overlap, total, err := countLeakage(cfg.TrainPath, cfg.ValidPath)
+		if err != nil {
+			return fmt.Errorf("failed to check the leakage: %s", err)
+		}
+		log.Printf("%d of %d validation lines appear in the training data", overlap, total)
+		if total > 0 && float64(overlap)/float64(total) > cfg.LeakageThreshold {
+			log.Printf("the validation data leaks into the training data, so the tuned %s may be too optimistic", cfg.Metric)
+		}
+	}
+
+	nTrials := cfg.NTrials
+	if cfg.ResumeIncomplete {
+		n, err := resumeIncompleteTrials(study, sampler)
+		if err != nil {
+			return fmt.Errorf("failed to resume incomplete trials: %s", err)
+		}
+		log.Printf("re-run %d incomplete trials", n)
+		nTrials += n
+	}
+
+	// create a context with cancel function
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	study.WithContext(ctx)
+
+	var cmdLog *commandLog
+	if cfg.CommandLog != "" {
+		if cmdLog, err = openCommandLog(cfg.CommandLog, cfg.RunID); err != nil {
+			return err
+		}
+		defer cmdLog.Close()
+	}
+	if cfg.ProbeJSONMeta {
+		if err = probeJSONMeta(ctx, cfg, cmdLog); err != nil {
+			return fmt.Errorf("failed to probe ffm-train: %s", err)
+		}
+	}
+	if err = checkLabels(evalDataPath(cfg), cfg.Metric); err != nil {
+		return err
+	}
+	if cfg.PrecomputeBin {
+		if err = precomputeBin(ctx, cfg, cmdLog); err != nil {
+			return fmt.Errorf("failed to precompute binary data: %s", err)
+		}
+	}
+	if cfg.WarmCache {
+		start := time.Now()
+		paths := warmCachePaths(cfg)
+		n, err := warmPageCache(ctx, paths)
+		if err != nil {
+			return fmt.Errorf("failed to warm the page cache: %s", err)
+		}
+		log.Printf("warmed the page cache with %d bytes of %s in %s",
+			n, strings.Join(paths, ", "), time.Since(start).Round(time.Millisecond))
+	}
+
+	r := &runner{cfg: cfg, queue: sampler, space: defaultSearchSpace, profiler: profiler, cmdLog: cmdLog}
+	if cfg.AutoScale {
+		r.space = autoScaleSearchSpace(summary.Examples)
+		log.Printf("auto-scaled the ranges to lambda=[%g, %g], eta=[%g, %g]",
+			r.space.lambdaLow, r.space.lambdaHigh, r.space.etaLow, r.space.etaHigh)
+	}
+	if cfg.Normalize {
+		r.baseline, err = baselineLogLoss(evalDataPath(cfg))
+		if err != nil {
+			return fmt.Errorf("failed to compute the baseline logloss: %s", err)
+		}
+		log.Printf("baseline logloss=%g", r.baseline)
+	}
+	if cfg.CacheDir != "" {
+		if r.cache, err = newEvalCache(cfg); err != nil {
+			return fmt.Errorf("failed to set up the cache: %s", err)
+		}
+	}
+	if cfg.LatentBuckets != "" {
+		if r.buckets, err = loadLatentBuckets(cfg.LatentBuckets); err != nil {
+			return fmt.Errorf("failed to load the latent buckets: %s", err)
+		}
+		r.space = envelope(r.buckets)
+	}
+	if cfg.SelectFields {
+		if r.fields, err = scanFields(cfg.TrainPath); err != nil {
+			return fmt.Errorf("failed to scan the fields: %s", err)
+		}
+		log.Printf("selecting from %d fields", len(r.fields))
+	}
+	if cfg.SearchSpaceCheck != searchSpaceCheckOff {
+		outside, err := checkSearchSpace(study, r.distributions())
+		if err != nil {
+			return fmt.Errorf("failed to check the search space: %s", err)
+		}
+		if len(outside) > 0 {
+			msg := "stored trials have params outside the current search space (" + formatOutside(outside) + ")"
+			if cfg.SearchSpaceCheck == searchSpaceCheckError {
+				return errors.New(msg)
+			}
+			log.Print(msg + ", which the sampler still learns from")
+		}
+	}
+	// enqueued first, so that it's evaluated before the other params.
+	if cfg.Baseline {
+		enqueued, err := r.enqueueBaseline(study)
+		if err != nil {
+			return fmt.Errorf("failed to enqueue the baseline: %s", err)
+		}
+		if enqueued {
+			nTrials++
+			log.Print("enqueued the default params as the baseline")
+		}
+	}
+	if cfg.WarmStart != "" {
+		entries, err := loadWarmStart(cfg.WarmStart, r.space, cfg)
+		if err != nil {
+			return fmt.Errorf("failed to load the warm-start params: %s", err)
+		}
+		for i := range entries {
+			if r.buckets != nil {
+				if entries[i], err = bucketParams(r.buckets, entries[i]); err != nil {
+					return fmt.Errorf("failed to load the warm-start params: entry %d: %s", i, err)
+				}
+			}
+			sampler.Enqueue(entries[i], map[string]string{"warm_start": strconv.Itoa(i)})
+		}
+		log.Printf("enqueued %d warm-start params", len(entries))
+	}
+	if cfg.Sampler == samplerGrid {
+		points, err := loadGrid(cfg.Grid, r.distributions())
+		if err != nil {
+			return fmt.Errorf("failed to load the grid: %s", err)
+		}
+		for i := range points {
+			sampler.Enqueue(points[i], map[string]string{"grid": strconv.Itoa(i)})
+		}
+		// the grid replaces -n-trials, but the resumed trials are still run.
+		nTrials += len(points) - cfg.NTrials
+		log.Printf("enqueued the %d points of the grid", len(points))
+	}
+	if cfg.WarmPool {
+		bin, args := wrapCommand(cfg, cfg.TrainBin, []string{"--server"})
+		if err = cmdLog.Log(-1, bin, args); err != nil {
+			return err
+		}
+		r.server, err = startTrainServer(ctx, bin, args, trainEnv(cfg))
+		if err != nil {
+			return fmt.Errorf("failed to start ffm-train server: %s", err)
+		}
+		defer r.server.Close()
+	}
+
+	if cfg.AutosaveEvery > 0 {
+		r.autosave = newAutosaver(ctx, cfg, cmdLog, features, study)
+	}
+	if cfg.SummaryJSON != "" {
+		r.summary = newSummaryWriter(cfg, study)
+	}
+	if cfg.MaxArtifactBytes > 0 {
+		r.janitor = newArtifactJanitor(cfg, study)
+	}
+	if r.store, err = newArtifactStore(cfg.ArtifactStore); err != nil {
+		return fmt.Errorf("failed to open the artifact store: %s", err)
+	}
+	if cfg.Journal != "" {
+		if r.journal, err = newJournalWriter(cfg, study); err != nil {
+			return fmt.Errorf("failed to open the journal: %s", err)
+		}
+	}
+	var backup *backuper
+	if cfg.Backup != "" {
+		backup = newBackuper(cfg, study)
+		// stops the snapshots on the returns before the trials end, where
+		// backup is still set.
+		defer func() {
+			if backup == nil {
+				return
+			}
+			if err := backup.Close(); err != nil {
+				log.Print("failed to back up the study:", err)
+			}
+		}()
+	}
+
+	// set signal handler
+	if !cfg.NoSignalHandler {
+		sigch := make(chan os.Signal, 1)
+		signal.Notify(sigch, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+		go func() {
+			sig, ok := <-sigch
+			if !ok {
+				return
+			}
+			cancel()
+			log.Print("catch a kill signal:", sig.String())
+		}()
+		defer func() {
+			signal.Stop(sigch)
+			close(sigch)
+		}()
+	}
+
+	var dash *dashboard
+	if logs != nil {
+		dash = newDashboard(cfg, study, os.Stdout, logs)
+		log.SetOutput(logs)
+		defer func() {
+			if dash != nil {
+				dash.Close()
+				log.SetOutput(os.Stderr)
+			}
+		}()
+	}
+
+	// run optimize with context. The workers take trials one by one from the
+	// shared budget instead of a static split, so that no worker idles while
+	// another one is still busy with slow trials.
+	workers := cfg.Concurrency
+	if workers < 1 {
+		log.Printf("concurrency=%d runs no trials, so a worker is run instead", workers)
+		workers = 1
+	}
+	var limiter *loadLimiter
+	if cfg.MaxLoad > 0 {
+		if limiter, err = newLoadLimiter(ctx, cfg.MaxLoad, workers); err != nil {
+			return fmt.Errorf("failed to monitor the load average: %s", err)
+		}
+	}
+	if cfg.PinCores != "" {
+		if r.cores, err = newCorePool(cfg.PinCores, workers); err != nil {
+			return err
+		}
+	}
+	r.procs = newProcSemaphore(cfg.MaxProcs)
+	var disk *diskMonitor
+	if cfg.MinFreeDisk > 0 {
+		if err = os.MkdirAll("./data/optuna", 0755); err != nil {
+			return err
+		}
+		if disk, err = newDiskMonitor(ctx, "./data/optuna", cfg.MinFreeDisk); err != nil {
+			return fmt.Errorf("failed to check the free disk space: %s", err)
+		}
+	}
+	var pause *pauseGate
+	if cfg.ControlFile != "" {
+		pause = newPauseGate(ctx, cfg.ControlFile)
+	}
+	r.budget = newTrialBudget(nTrials)
+	var wg sync.WaitGroup
+	var abortOnce sync.Once
+	var abortErr error
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// the error of the objective tells a failed trial apart from
+			// the errors of the storage, which Optimize returns too.
+			var objErr error
+			objective := func(trial goptuna.Trial) (float64, error) {
+				var v float64
+				v, objErr = r.objective(trial)
+				return v, objErr
+			}
+			for r.budget.Take() {
+				if disk.Low() {
+					return
+				}
+				if !pause.Wait() {
+					return
+				}
+				if !limiter.Acquire() {
+					return
+				}
+				// goptuna returns the error of a pruned trial too.
+				objErr = nil
+				err := study.Optimize(objective, 1)
+				limiter.Release()
+				if r.autosave != nil {
+					r.autosave.TrialFinished()
+				}
+				r.summary.TrialFinished()
+				r.janitor.TrialFinished()
+				r.journal.TrialFinished()
+				if err == nil || err == goptuna.ErrTrialPruned {
+					continue
+				}
+				if err == objErr {
+					r.failures.Add(err)
+				}
+				if err == objErr && cfg.ContinueOnError && ctx.Err() == nil {
+					log.Print("trial failed:", err)
+					continue
+				}
+				if err == objErr && !cfg.ContinueOnError {
+					abortOnce.Do(func() {
+						abortErr = err
+						cancel()
+					})
+				}
+				log.Print("optimize catch error:", err)
+				return
+			}
+		}()
+	}
+	wg.Wait()
+	if dash != nil {
+		dash.Close()
+		log.SetOutput(os.Stderr)
+		dash = nil
+	}
+	if failures := r.failures.String(); failures != "" {
+		log.Print("failed trials: ", failures)
+	}
+	if n := atomic.LoadInt64(&r.attrErrors); n > 0 {
+		log.Printf("WARNING: failed to store %d user attrs of the trials", n)
+	}
+	if profiler != nil {
+		profiler.Report()
+	}
+	if r.autosave != nil {
+		r.autosave.Close()
+	}
+	r.janitor.Close()
+	if backup != nil {
+		if err = backup.Close(); err != nil {
+			log.Print("failed to back up the study:", err)
+		}
+		backup = nil
+	}
+	if abortErr != nil {
+		return fmt.Errorf("aborted on a failed trial by -continue-on-error=false: %s", abortErr)
+	}
+	if err = disk.Err(); err != nil {
+		return err
+	}
+	if markDone != nil {
+		markDone()
+	}
+	if !leader {
+		log.Print("the leader retrains and reports the results")
+		return nil
+	}
+	if cfg.LeaderElection {
+		if err = waitForWorkers(ctx, study, cfg.RunID); err != nil {
+			return fmt.Errorf("failed to wait for the other workers: %s", err)
+		}
+	}
+
+	// print best hyper-parameters and the result
+	best, err := getBestTrial(study, bestFilter(cfg), cfg.TieBreak)
+	if err == goptuna.ErrNoCompletedTrials && cfg.MinTrialsForBest > 0 {
+		return fmt.Errorf("no completed trials after the first %d trials of -min-trials-for-best", cfg.MinTrialsForBest)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get the best trial: %s", err)
+	}
+	var reevals []reevaluation
+	if cfg.ReevalTopK > 0 {
+		if best, reevals, err = r.reevaluateTop(ctx, study); err != nil {
+			return fmt.Errorf("failed to re-evaluate the top trials: %s", err)
+		}
+		log.Printf("selected trial=%d by the re-evaluation", best.Number)
+	}
+	if err = r.summary.Write(best); err != nil {
+		return fmt.Errorf("failed to write the study summary: %s", err)
+	}
+	params, err := trialParams(best)
+	if err != nil {
+		return err
+	}
+	lmd, eta, latent, err := effectiveParams(params)
+	if err != nil {
+		return fmt.Errorf("trial %d: %s", best.Number, err)
+	}
+	log.Printf("Best evaluation=%f (lambda=%g, eta=%g, latent=%d)", best.Value, lmd, eta, latent)
+	ties, err := tiedTrials(study, bestFilter(cfg), best)
+	if err != nil {
+		return fmt.Errorf("failed to find the ties of the best trial: %s", err)
+	}
+	if ties != nil {
+		log.Printf("the best evaluation is tied by the trials %v, and trial=%d is selected by -tie-break %s", ties, best.Number, cfg.TieBreak)
+		if cfg.TieBreak == tieBreakFail {
+			return fmt.Errorf("the best evaluation=%f is tied by the trials %v", best.Value, ties)
+		}
+	}
+	if cfg.Baseline {
+		c, err := compareBaseline(study, best)
+		if err != nil {
+			return fmt.Errorf("failed to compare with the baseline: %s", err)
+		}
+		if c != nil {
+			log.Printf("improvement over the baseline trial=%d with evaluation=%f: %+f (%+.2f%%)",
+				c.Number, c.Value, c.Improvement, c.ImprovementPercent)
+		} else {
+			log.Print("the baseline has no completed trial to compare with")
+		}
+	}
+	if cfg.MinTrialsForBest > 0 {
+		if overall, err := getBestTrial(study, cfg.BestFilter, cfg.TieBreak); err == nil && overall.Number != best.Number {
+			log.Printf("the best of all trials is trial=%d with evaluation=%f, before -min-trials-for-best=%d",
+				overall.Number, overall.Value, cfg.MinTrialsForBest)
+		}
+	}
+
+	var model *ModelInfo
+	if cfg.FinalModel != "" {
+		path := expandModelPath(cfg.FinalModel, best)
+		if err = retrain(ctx, cfg, cmdLog, best, path); err != nil {
+			return fmt.Errorf("failed to retrain the best trial: %s", err)
+		}
+		log.Printf("wrote the model of trial=%d to %s", best.Number, path)
+		info, err := reportModel(path, best, features)
+		if err != nil {
+			return fmt.Errorf("failed to summarize the model: %s", err)
+		}
+		model = &info
+		if cfg.Artifact != "" {
+			if err = writeArtifact(cfg.Artifact, cfg, best, path, model); err != nil {
+				return fmt.Errorf("failed to write the artifact: %s", err)
+			}
+			log.Printf("wrote the artifact of trial=%d to %s", best.Number, cfg.Artifact)
+		}
+	}
+	if cfg.MultiObjective {
+		if err = reportParetoFront(cfg, study); err != nil {
+			return fmt.Errorf("failed to report the Pareto front: %s", err)
+		}
+	}
+	if cfg.BestJSON != "" {
+		result, err := newBestResult(study, cfg, best)
+		if err != nil {
+			return fmt.Errorf("failed to summarize the best trial: %s", err)
+		}
+		result.Model = model
+		result.Reevaluated = reevals
+		if err = writeBestJSON(cfg.BestJSON, result); err != nil {
+			return fmt.Errorf("failed to write the best trial: %s", err)
+		}
+	}
+	if cfg.GenScript != "" {
+		if err = writeTrainScript(cfg.GenScript, cfg, best); err != nil {
+			return fmt.Errorf("failed to write the training script: %s", err)
+		}
+		log.Printf("wrote the training script of trial=%d to %s", best.Number, cfg.GenScript)
+	}
+	if cfg.CSV != "" {
+		trials, err := study.GetTrials()
+		if err != nil {
+			return fmt.Errorf("failed to get trials: %s", err)
+		}
+		if err = exportCSV(cfg.CSV, trials, cfg.CSVAppend); err != nil {
+			return fmt.Errorf("failed to export trials to CSV: %s", err)
+		}
+	}
+	return nil
+}
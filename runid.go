@@ -0,0 +1,41 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"time"
+
+	"github.com/c-bata/goptuna"
+)
+
+// runIDAttrKey is a system attr of the trials holding the run which
+// evaluated them.
+const runIDAttrKey = "run_id"
+
+// newRunID generates a random UUID (version 4).
+func newRunID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// storeRunID records the start of the run as a system attr of the study. A
+// study may be resumed by several runs, so each run has its own key. The
+// workers of a distributed run share the key, and the first one is kept.
+func storeRunID(study *goptuna.Study, runID string) error {
+	key := "run:" + runID
+	_, err := setStudySystemAttrIfMissing(study, key, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		// another worker may have set it after the lookup.
+		if attrs, aerr := study.GetSystemAttrs(); aerr == nil {
+			if _, ok := attrs[key]; ok {
+				return nil
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,11 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package main
+
+import "os"
+
+// maxRSSKB is not supported on this platform.
+func maxRSSKB(state *os.ProcessState) (int64, bool) {
+	return 0, false
+}
@@ -0,0 +1,26 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package main
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// maxRSSKB returns the maximum resident set size of the exited process in kilobytes.
+func maxRSSKB(state *os.ProcessState) (int64, bool) {
+	if state == nil {
+		return 0, false
+	}
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok || rusage == nil {
+		return 0, false
+	}
+	// ru_maxrss is reported in bytes on macOS but in kilobytes elsewhere.
+	if runtime.GOOS == "darwin" {
+		return int64(rusage.Maxrss) / 1024, true
+	}
+	return int64(rusage.Maxrss), true
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Store puts the files to a bucket of S3 or an S3-compatible server by the
+// REST API signed by AWS Signature Version 4. The credentials and the region
+// are read from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN
+// and AWS_REGION, and AWS_ENDPOINT_URL points to a server other than S3,
+// e.g. http://localhost:9000 of MinIO. The objects are addressed by path,
+// which the S3-compatible servers support.
+type s3Store struct {
+	endpoint string
+	bucket   string
+	prefix   string
+	region   string
+
+	accessKey    string
+	secretKey    string
+	sessionToken string
+
+	client *http.Client
+}
+
+func newS3Store(bucket, prefix string) (*s3Store, error) {
+	s := &s3Store{
+		endpoint:     strings.TrimRight(os.Getenv("AWS_ENDPOINT_URL"), "/"),
+		bucket:       bucket,
+		prefix:       prefix,
+		region:       os.Getenv("AWS_REGION"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		client:       &http.Client{Timeout: 10 * time.Minute},
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required for s3://")
+	}
+	if s.region == "" {
+		s.region = "us-east-1"
+	}
+	if s.endpoint == "" {
+		s.endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", s.region)
+	}
+	return s, nil
+}
+
+// Put uploads the object by a PUT request. The payload is left unsigned, so
+// that a large model is streamed instead of hashed first.
+func (s *s3Store) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	key := name
+	if s.prefix != "" {
+		key = s.prefix + "/" + name
+	}
+	path := "/" + s.bucket + "/" + key
+	// a body of 0 bytes is sent chunked instead of with the length.
+	var body io.Reader = http.NoBody
+	if size > 0 {
+		body = ioutil.NopCloser(r)
+	}
+	req, err := http.NewRequest(http.MethodPut, s.endpoint+s3EscapePath(path), body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.ContentLength = size
+	s.sign(req, s3EscapePath(path), time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("PUT %s: %s: %s", path, resp.Status, body)
+	}
+	return nil
+}
+
+// sign adds the headers of Signature Version 4 to the request.
+func (s *s3Store) sign(req *http.Request, escapedPath string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", "UNSIGNED-PAYLOAD")
+	if s.sessionToken != "" {
+		req.Header.Set("x-amz-security-token", s.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method, escapedPath, "", canonicalHeaders.String(), signedHeaders, "UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{date, s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3EscapePath escapes the path as Signature Version 4 expects: every byte
+// but the unreserved characters and the slashes.
+func s3EscapePath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
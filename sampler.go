@@ -0,0 +1,302 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+
+	"github.com/c-bata/goptuna"
+	"github.com/c-bata/goptuna/tpe"
+)
+
+// tpeStartupTrials is the number of trials sampled randomly before TPE,
+// which is the default of goptuna's TPE sampler.
+const tpeStartupTrials = 10
+
+var _ goptuna.Sampler = &queuedSampler{}
+var _ goptuna.Sampler = &seededSampler{}
+
+// queuedParams is a set of params which is evaluated by the next trial.
+type queuedParams struct {
+	params map[string]interface{}
+	// systemAttrs are stored on the trial which takes the params.
+	systemAttrs map[string]string
+}
+
+// queuedSampler suggests the enqueued params to the next trials before
+// falling back to the wrapped sampler. goptuna doesn't provide a way to
+// enqueue trials, so this is used to re-run or warm-start specific params.
+type queuedSampler struct {
+	base     goptuna.Sampler
+	mu       sync.Mutex
+	queue    []queuedParams
+	assigned map[int]queuedParams
+}
+
+func newQueuedSampler(base goptuna.Sampler) *queuedSampler {
+	return &queuedSampler{
+		base:     base,
+		assigned: make(map[int]queuedParams, 8),
+	}
+}
+
+// Enqueue adds params which is evaluated by a following trial.
+func (s *queuedSampler) Enqueue(params map[string]interface{}, systemAttrs map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queue = append(s.queue, queuedParams{
+		params:      params,
+		systemAttrs: systemAttrs,
+	})
+}
+
+// Len returns the number of params which are not taken by any trial yet.
+func (s *queuedSampler) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.queue)
+}
+
+// Sample a parameter for a given distribution.
+func (s *queuedSampler) Sample(
+	study *goptuna.Study,
+	trial goptuna.FrozenTrial,
+	paramName string,
+	paramDistribution interface{},
+) (float64, error) {
+	q, ok := s.take(study, trial.ID)
+	if ok {
+		if xr, found := q.params[paramName]; found {
+			ir, err := toInternalRepr(paramDistribution, xr)
+			if err == nil {
+				return ir, nil
+			}
+		}
+	}
+	return s.base.Sample(study, trial, paramName, paramDistribution)
+}
+
+// Param returns the queued value of the param for the trial, if any.
+func (s *queuedSampler) Param(study *goptuna.Study, trialID int, name string) (interface{}, bool) {
+	q, ok := s.take(study, trialID)
+	if !ok {
+		return nil, false
+	}
+	xr, ok := q.params[name]
+	return xr, ok
+}
+
+// take returns the queued params assigned to the trial. The first call of a
+// trial pops the head of the queue.
+func (s *queuedSampler) take(study *goptuna.Study, trialID int) (queuedParams, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if q, ok := s.assigned[trialID]; ok {
+		return q, true
+	}
+	if len(s.queue) == 0 {
+		return queuedParams{}, false
+	}
+	q := s.queue[0]
+	s.queue = s.queue[1:]
+	s.assigned[trialID] = q
+
+	for k, v := range q.systemAttrs {
+		if err := study.Storage.SetTrialSystemAttr(trialID, k, v); err != nil {
+			log.Printf("WARNING: failed to store the system attr %s of trial %d: %s", k, trialID, err)
+		}
+	}
+	return q, true
+}
+
+// Forget drops the params assigned to the finished trial, and the state of
+// the trial of the wrapped sampler, so that a long sweep doesn't keep them.
+func (s *queuedSampler) Forget(trialID int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	delete(s.assigned, trialID)
+	s.mu.Unlock()
+	if f, ok := s.base.(interface{ Forget(trialID int) }); ok {
+		f.Forget(trialID)
+	}
+}
+
+// toInternalRepr converts an external representation of the param into the
+// internal one. Unlike goptuna.ToInternalRepresentation, it returns an error
+// instead of panicking when the value doesn't fit the distribution.
+func toInternalRepr(distribution interface{}, xr interface{}) (float64, error) {
+	switch d := distribution.(type) {
+	case goptuna.UniformDistribution:
+		return floatInRange(xr, d.Low, d.High)
+	case goptuna.LogUniformDistribution:
+		return floatInRange(xr, d.Low, d.High)
+	case goptuna.DiscreteUniformDistribution:
+		return floatInRange(xr, d.Low, d.High)
+	case goptuna.IntUniformDistribution:
+		var v int
+		switch x := xr.(type) {
+		case int:
+			v = x
+		case float64:
+			if x != float64(int(x)) {
+				return 0, fmt.Errorf("%v is not an integer", xr)
+			}
+			v = int(x)
+		default:
+			return 0, fmt.Errorf("%v is not an integer", xr)
+		}
+		if v < d.Low || v > d.High {
+			return 0, fmt.Errorf("%d is out of range [%d, %d]", v, d.Low, d.High)
+		}
+		return float64(v), nil
+	case goptuna.CategoricalDistribution:
+		v := fmt.Sprintf("%v", xr)
+		for i := range d.Choices {
+			if d.Choices[i] == v {
+				return float64(i), nil
+			}
+		}
+		return 0, fmt.Errorf("%q is not in choices %v", v, d.Choices)
+	}
+	return 0, errors.New("unknown distribution")
+}
+
+func floatInRange(xr interface{}, low, high float64) (float64, error) {
+	var v float64
+	switch x := xr.(type) {
+	case float64:
+		v = x
+	case int:
+		v = float64(x)
+	default:
+		return 0, fmt.Errorf("%v is not a number", xr)
+	}
+	if v < low || v > high {
+		return 0, fmt.Errorf("%g is out of range [%g, %g]", v, low, high)
+	}
+	return v, nil
+}
+
+// seededSampler samples each trial with a random state derived from the seed
+// and the trial number. goptuna doesn't expose the random state of its
+// samplers, so it can't be checkpointed and restored. Instead, no state is
+// carried over between trials: a resumed study continues the same trajectory
+// as an uninterrupted one as long as it sees the same stored trials. With
+// concurrent workers the stored trials depend on timing, so it is exact only
+// when trials are run one at a time.
+type seededSampler struct {
+	seed int64
+	// window is the number of recent finished trials which TPE observes,
+	// or 0 for all trials.
+	window   int
+	mu       sync.Mutex
+	samplers map[int]goptuna.Sampler
+	// view is the study seen by TPE with the window.
+	view *goptuna.Study
+}
+
+func newSeededSampler(seed int64, window int) *seededSampler {
+	return &seededSampler{
+		seed:     seed,
+		window:   window,
+		samplers: make(map[int]goptuna.Sampler, 8),
+	}
+}
+
+// Sample a parameter for a given distribution.
+func (s *seededSampler) Sample(
+	study *goptuna.Study,
+	trial goptuna.FrozenTrial,
+	paramName string,
+	paramDistribution interface{},
+) (float64, error) {
+	// a single-valued param like train_seed is recorded as is.
+	if d, ok := paramDistribution.(goptuna.IntUniformDistribution); ok && d.Single() {
+		return float64(d.Low), nil
+	}
+
+	sampler, err := s.samplerOf(study, trial)
+	if err != nil {
+		return 0, err
+	}
+	if s.window > 0 {
+		if study, err = s.viewOf(study); err != nil {
+			return 0, err
+		}
+	}
+	return sampler.Sample(study, trial, paramName, paramDistribution)
+}
+
+// viewOf returns the study whose trials are limited to the window. goptuna's
+// TPE reads the observations from study.GetTrials(), so the view is the same
+// study loaded on a storage which returns only the recent trials.
+func (s *seededSampler) viewOf(study *goptuna.Study) (*goptuna.Study, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.view != nil {
+		return s.view, nil
+	}
+	name, err := study.Storage.GetStudyNameFromID(study.ID)
+	if err != nil {
+		return nil, err
+	}
+	view, err := goptuna.LoadStudy(name,
+		goptuna.StudyOptionStorage(&windowedStorage{Storage: study.Storage, window: s.window}))
+	if err != nil {
+		return nil, err
+	}
+	s.view = view
+	return view, nil
+}
+
+// samplerOf returns the sampler of the trial, which is created on the first
+// param of the trial. Like goptuna's TPE sampler, the trials are sampled
+// randomly until tpeStartupTrials trials are finished, but by a random
+// sampler seeded for the trial because TPE's one can't be seeded. The startup
+// counts all finished trials regardless of the window.
+func (s *seededSampler) samplerOf(study *goptuna.Study, trial goptuna.FrozenTrial) (goptuna.Sampler, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sampler, ok := s.samplers[trial.ID]; ok {
+		return sampler, nil
+	}
+
+	trials, err := study.GetTrials()
+	if err != nil {
+		return nil, err
+	}
+	var finished int
+	for i := range trials {
+		if trials[i].State == goptuna.TrialStateComplete || trials[i].State == goptuna.TrialStatePruned {
+			finished++
+		}
+	}
+
+	seed := trialSeed(s.seed, trial.Number)
+	var sampler goptuna.Sampler
+	if finished < tpeStartupTrials {
+		sampler = goptuna.NewRandomSearchSampler(goptuna.RandomSearchSamplerOptionSeed(seed))
+	} else {
+		sampler = tpe.NewSampler(tpe.SamplerOptionSeed(seed))
+	}
+	s.samplers[trial.ID] = sampler
+	return sampler, nil
+}
+
+// Forget drops the sampler of the finished trial.
+func (s *seededSampler) Forget(trialID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.samplers, trialID)
+}
+
+// trialSeed derives a seed of the trial from the study seed.
+func trialSeed(seed int64, number int) int64 {
+	x := uint64(seed) + uint64(number)*0x9E3779B97F4A7C15
+	return rand.New(rand.NewSource(int64(x))).Int63()
+}
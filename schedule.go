@@ -0,0 +1,26 @@
+package main
+
+import "fmt"
+
+// etaDecayParam is the param of the decay of the learning rate, which is
+// tuned only when -eta-decay-flag names the flag of a libffm fork with
+// learning-rate schedules. eta is its initial learning rate.
+const etaDecayParam = "eta_decay"
+
+// scheduleArgs returns the ffm-train arguments of the schedule params, or
+// nil if -eta-decay-flag is unset or the params lack eta_decay, like the
+// trials before it was enabled.
+func scheduleArgs(cfg *Config, params map[string]interface{}) ([]string, error) {
+	if cfg.EtaDecayFlag == "" {
+		return nil, nil
+	}
+	v, ok := params[etaDecayParam]
+	if !ok {
+		return nil, nil
+	}
+	decay, ok := v.(float64)
+	if !ok {
+		return nil, fmt.Errorf("param %q is not a float: %v", etaDecayParam, v)
+	}
+	return []string{cfg.EtaDecayFlag, formatFloatArg(decay, cfg.ParamPrecision)}, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+)
+
+// storageSchemaVersion is the version of the RDB schema of the goptuna
+// version this tool is built with. Bump it when upgrading goptuna changes
+// the tables below.
+const storageSchemaVersion = "goptuna-v0.0.5"
+
+// storageSchema is the tables and columns of the RDB storage which this tool
+// reads and writes.
+var storageSchema = []struct {
+	table   string
+	columns []string
+}{
+	{"studies", []string{"study_id", "study_name", "direction"}},
+	{"study_user_attributes", []string{"study_user_attribute_id", "study_id", "key", "value_json"}},
+	{"study_system_attributes", []string{"study_system_attribute_id", "study_id", "key", "value_json"}},
+	{"trials", []string{"trial_id", "study_id", "state", "value", "datetime_start", "datetime_complete"}},
+	{"trial_user_attributes", []string{"trial_user_attribute_id", "trial_id", "key", "value_json"}},
+	{"trial_system_attributes", []string{"trial_system_attribute_id", "trial_id", "key", "value_json"}},
+	{"trial_params", []string{"param_id", "trial_id", "param_name", "param_value", "distribution_json"}},
+	{"trial_values", []string{"trial_value_id", "trial_id", "step", "value"}},
+}
+
+// schemaVersionModel is a row recording the schema version of the database.
+// goptuna doesn't version its schema, so this tool keeps its own table.
+type schemaVersionModel struct {
+	Key   string `gorm:"column:key;PRIMARY_KEY;type:varchar(512)"`
+	Value string `gorm:"column:value;type:varchar(512)"`
+}
+
+func (m schemaVersionModel) TableName() string {
+	return "goptuna_libffm_schema"
+}
+
+const schemaVersionKey = "schema_version"
+
+// checkSchema returns an error if the database was created by an
+// incompatible goptuna version. An empty database passes because the tables
+// are created by the auto-migration.
+func checkSchema(db *gorm.DB) error {
+	detected := "unknown"
+	if db.HasTable(&schemaVersionModel{}) {
+		var row schemaVersionModel
+		err := db.Where("key = ?", schemaVersionKey).First(&row).Error
+		if err != nil && !gorm.IsRecordNotFoundError(err) {
+			return fmt.Errorf("failed to read the schema version: %s", err)
+		}
+		if err == nil {
+			detected = row.Value
+		}
+	}
+	if detected != "unknown" && detected != storageSchemaVersion {
+		return schemaError(detected, "the schema version differs")
+	}
+
+	var found int
+	for _, s := range storageSchema {
+		if db.HasTable(s.table) {
+			found++
+		}
+	}
+	if found == 0 {
+		return nil
+	}
+	for _, s := range storageSchema {
+		if !db.HasTable(s.table) {
+			return schemaError(detected, fmt.Sprintf("table %q is missing", s.table))
+		}
+		for _, c := range s.columns {
+			if !db.Dialect().HasColumn(s.table, c) {
+				return schemaError(detected, fmt.Sprintf("table %q has no column %q", s.table, c))
+			}
+		}
+	}
+	return nil
+}
+
+func schemaError(detected, reason string) error {
+	return fmt.Errorf(
+		"incompatible storage schema (detected %s, expected %s): %s; "+
+			"migrate the database or start fresh with another -dsn",
+		detected, storageSchemaVersion, reason)
+}
+
+// stampSchema records the schema version if the database has no version yet.
+func stampSchema(db *gorm.DB) error {
+	if err := db.AutoMigrate(&schemaVersionModel{}).Error; err != nil {
+		return err
+	}
+	row := schemaVersionModel{Key: schemaVersionKey}
+	return db.Where(row).Attrs(schemaVersionModel{Value: storageSchemaVersion}).FirstOrCreate(&row).Error
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/c-bata/goptuna"
+)
+
+// writeTrainScript writes an executable shell script to path which retrains
+// the trial by the same ffm-train command as -final-model. The paths of the
+// binary and the training data are absolute, so the script runs from any
+// directory; the model is written to the first argument, or ffm-best.model.
+func writeTrainScript(path string, cfg *Config, trial goptuna.FrozenTrial) error {
+	script, err := trainScript(cfg, trial)
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(path, script, 0755); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of an existing file.
+	return os.Chmod(path, 0755)
+}
+
+// trainScript returns the script of writeTrainScript.
+func trainScript(cfg *Config, trial goptuna.FrozenTrial) ([]byte, error) {
+	args, err := retrainArgs(cfg, trial)
+	if err != nil {
+		return nil, err
+	}
+	bin := cfg.TrainBin
+	if p, err := exec.LookPath(bin); err == nil {
+		bin = p
+	}
+	if bin, err = filepath.Abs(bin); err != nil {
+		return nil, err
+	}
+	data, err := trialData(cfg, trial)
+	if err != nil {
+		return nil, err
+	}
+	trainPath, err := filepath.Abs(data.train)
+	if err != nil {
+		return nil, err
+	}
+	cmd := append([]string{bin}, args...)
+	cmd = append(cmd, trainPath)
+
+	b := &bytes.Buffer{}
+	fmt.Fprintln(b, "#!/bin/sh")
+	fmt.Fprintf(b, "# Retrains trial %d of study %q tuned by goptuna-libffm.\n", trial.Number, cfg.StudyName)
+	fmt.Fprintf(b, "# generated: %s\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(b, "# best value: %g (%s)\n", trial.Value, cfg.Metric)
+	if data.train != cfg.TrainPath {
+		fmt.Fprintf(b, "# data: %s with the fields selected by -select-fields\n", cfg.TrainPath)
+	}
+	fmt.Fprintln(b, "#")
+	fmt.Fprintln(b, "# usage: $0 [model path]")
+	fmt.Fprintln(b, "set -e")
+	fmt.Fprintf(b, "exec %s \"${1:-ffm-best.model}\"\n", shellJoin(cmd))
+	return b.Bytes(), nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+)
+
+// searchSpace is the ranges of the log-uniform params.
+type searchSpace struct {
+	lambdaLow, lambdaHigh float64
+	etaLow, etaHigh       float64
+}
+
+var defaultSearchSpace = searchSpace{
+	lambdaLow:  1e-6,
+	lambdaHigh: 1,
+	etaLow:     1e-6,
+	etaHigh:    1,
+}
+
+// autoScaleSearchSpace narrows the ranges of eta and lambda by the number of
+// training examples with the following heuristic:
+//
+//   - larger datasets take more updates per epoch, so the upper bound of eta
+//     is 1/log10(examples) in [0.05, 1] (0.17 for 1M examples).
+//   - larger datasets need less regularization, so the upper bound of lambda
+//     is 100/examples in [1e-4, 1] (1e-4 for 1M examples).
+//
+// The lower bounds are 1e-4 and 1e-5 times the upper bounds of eta and lambda
+// respectively, which covers the defaults of libffm (eta=0.2, lambda=2e-5)
+// for typical dataset sizes.
+func autoScaleSearchSpace(examples int64) searchSpace {
+	n := math.Max(float64(examples), 10)
+	etaHigh := clamp(1/math.Log10(n), 0.05, 1)
+	lambdaHigh := clamp(100/n, 1e-4, 1)
+	return searchSpace{
+		lambdaLow:  lambdaHigh * 1e-5,
+		lambdaHigh: lambdaHigh,
+		etaLow:     etaHigh * 1e-4,
+		etaHigh:    etaHigh,
+	}
+}
+
+func clamp(x, low, high float64) float64 {
+	return math.Min(math.Max(x, low), high)
+}
+
+// latentBucket is the ranges of eta and lambda when latent is at most
+// maxLatent.
+type latentBucket struct {
+	maxLatent int
+	space     searchSpace
+}
+
+// paramName returns the name of the param in the bucket, e.g. "eta_k4".
+// Each bucket has its own params because TPE models a param by the values
+// sampled from a single distribution.
+func (b latentBucket) paramName(base string) string {
+	return fmt.Sprintf("%s_k%d", base, b.maxLatent)
+}
+
+// loadLatentBuckets reads a JSON array like
+//
+//	[{"max_latent": 4, "lambda": [1e-6, 1e-2], "eta": [1e-3, 1]}, ...]
+//
+// sorted by max_latent. The last bucket must cover the largest latent.
+func loadLatentBuckets(path string) ([]latentBucket, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []struct {
+		MaxLatent int       `json:"max_latent"`
+		Lambda    []float64 `json:"lambda"`
+		Eta       []float64 `json:"eta"`
+	}
+	if err = json.Unmarshal(b, &entries); err != nil {
+		return nil, fmt.Errorf("%s is not a JSON array of buckets: %s", path, err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%s has no buckets", path)
+	}
+	buckets := make([]latentBucket, len(entries))
+	for i, e := range entries {
+		if i > 0 && e.MaxLatent <= entries[i-1].MaxLatent {
+			return nil, fmt.Errorf("bucket %d of %s: max_latent must be larger than the previous one", i, path)
+		}
+		if len(e.Lambda) != 2 || len(e.Eta) != 2 ||
+			!(0 < e.Lambda[0] && e.Lambda[0] < e.Lambda[1]) || !(0 < e.Eta[0] && e.Eta[0] < e.Eta[1]) {
+			return nil, fmt.Errorf("bucket %d of %s: lambda and eta must be [low, high] with 0 < low < high", i, path)
+		}
+		buckets[i] = latentBucket{
+			maxLatent: e.MaxLatent,
+			space: searchSpace{
+				lambdaLow:  e.Lambda[0],
+				lambdaHigh: e.Lambda[1],
+				etaLow:     e.Eta[0],
+				etaHigh:    e.Eta[1],
+			},
+		}
+	}
+	if last := buckets[len(buckets)-1].maxLatent; last < 16 {
+		return nil, fmt.Errorf("the last bucket of %s must cover latent=16, got max_latent=%d", path, last)
+	}
+	return buckets, nil
+}
+
+// bucketOf returns the bucket of the latent.
+func bucketOf(buckets []latentBucket, latent int) latentBucket {
+	for _, b := range buckets {
+		if latent <= b.maxLatent {
+			return b
+		}
+	}
+	return buckets[len(buckets)-1]
+}
+
+// envelope returns the smallest space which covers every bucket.
+func envelope(buckets []latentBucket) searchSpace {
+	s := buckets[0].space
+	for _, b := range buckets[1:] {
+		s.lambdaLow = math.Min(s.lambdaLow, b.space.lambdaLow)
+		s.lambdaHigh = math.Max(s.lambdaHigh, b.space.lambdaHigh)
+		s.etaLow = math.Min(s.etaLow, b.space.etaLow)
+		s.etaHigh = math.Max(s.etaHigh, b.space.etaHigh)
+	}
+	return s
+}
+
+// bucketParams renames lambda and eta of the validated params to the ones of
+// the bucket of latent, checking that they are in its ranges.
+func bucketParams(buckets []latentBucket, params map[string]interface{}) (map[string]interface{}, error) {
+	latent, err := intParam(params, "latent")
+	if err != nil {
+		return nil, err
+	}
+	b := bucketOf(buckets, latent)
+	if err = checkFloat(params, "lambda", b.space.lambdaLow, b.space.lambdaHigh); err != nil {
+		return nil, fmt.Errorf("latent=%d: %s", latent, err)
+	}
+	if err = checkFloat(params, "eta", b.space.etaLow, b.space.etaHigh); err != nil {
+		return nil, fmt.Errorf("latent=%d: %s", latent, err)
+	}
+	renamed := make(map[string]interface{}, len(params))
+	for k, v := range params {
+		switch k {
+		case "lambda", "eta":
+			renamed[b.paramName(k)] = v
+		default:
+			renamed[k] = v
+		}
+	}
+	return renamed, nil
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/c-bata/goptuna"
+)
+
+// The states of a sweep of -serve.
+const (
+	sweepRunning  = "running"
+	sweepDone     = "done"
+	sweepFailed   = "failed"
+	sweepCanceled = "canceled"
+)
+
+// sweepRetention is how long the status of a finished sweep is kept.
+const sweepRetention = time.Hour
+
+// sweep is a RunStudy run by -serve.
+type sweep struct {
+	cfg     *Config
+	cancel  context.CancelFunc
+	started time.Time
+
+	mu       sync.Mutex
+	state    string
+	err      error
+	finished time.Time
+}
+
+// sweepStatus is the JSON of a sweep returned by the API.
+type sweepStatus struct {
+	ID       string         `json:"id"`
+	Study    string         `json:"study"`
+	State    string         `json:"state"`
+	Error    string         `json:"error,omitempty"`
+	Started  time.Time      `json:"started"`
+	Finished *time.Time     `json:"finished,omitempty"`
+	Trials   map[string]int `json:"trials,omitempty"`
+	Best     *bestResult    `json:"best,omitempty"`
+}
+
+// sweepServer serves the HTTP API of -serve:
+//
+//	POST /sweeps         starts a sweep of the JSON of a sweepRequest in the body
+//	GET /sweeps/<id>     returns the status and the best trial of the sweep
+//	DELETE /sweeps/<id>  cancels the sweep
+//
+// The fields of the body override the flags of the server, like
+// {"StudyName": "s1", "NTrials": 100}, and the sweep is identified by its
+// RunID. A sweep runs at a time, because the files of the trials under
+// ./data/optuna are named by the trial numbers, which the studies share, and
+// the finished sweeps are forgotten after sweepRetention.
+type sweepServer struct {
+	ctx context.Context
+	// args are the flags of the server, which are the defaults of the sweeps.
+	args []string
+
+	mu     sync.Mutex
+	sweeps map[string]*sweep
+	wg     sync.WaitGroup
+}
+
+// serveAPI serves the API on cfg.Serve until SIGINT or SIGTERM, which cancel
+// the running sweeps and wait for them.
+func serveAPI(cfg *Config, args []string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := &sweepServer{ctx: ctx, args: args, sweeps: make(map[string]*sweep)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sweeps", s.handleSweeps)
+	mux.HandleFunc("/sweeps/", s.handleSweep)
+	srv := &http.Server{Addr: cfg.Serve, Handler: mux}
+
+	sigch := make(chan os.Signal, 1)
+	signal.Notify(sigch, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigch)
+	go func() {
+		sig := <-sigch
+		log.Print("catch a kill signal:", sig.String())
+		srv.Shutdown(context.Background())
+	}()
+
+	log.Printf("serving the API on %s", cfg.Serve)
+	err := srv.ListenAndServe()
+	cancel()
+	s.wg.Wait()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// handleSweeps starts a sweep.
+func (s *sweepServer) handleSweeps(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg, err := s.sweepConfig(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	if _, ok := s.sweeps[cfg.RunID]; ok {
+		s.mu.Unlock()
+		http.Error(w, fmt.Sprintf("sweep %s already exists", cfg.RunID), http.StatusConflict)
+		return
+	}
+	if id := s.pruneSweeps(); id != "" {
+		s.mu.Unlock()
+		http.Error(w, fmt.Sprintf("sweep %s is running, and a sweep runs at a time", id), http.StatusConflict)
+		return
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	sw := &sweep{cfg: cfg, cancel: cancel, started: time.Now(), state: sweepRunning}
+	s.sweeps[cfg.RunID] = sw
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+		err := RunStudy(ctx, cfg)
+		sw.mu.Lock()
+		defer sw.mu.Unlock()
+		sw.finished = time.Now()
+		switch {
+		case ctx.Err() != nil:
+			sw.state = sweepCanceled
+		case err != nil:
+			sw.state = sweepFailed
+		default:
+			sw.state = sweepDone
+		}
+		sw.err = err
+		log.Printf("sweep %s is %s", cfg.RunID, sw.state)
+	}()
+	log.Printf("started sweep %s of study %s", cfg.RunID, cfg.StudyName)
+	w.Header().Set("Location", "/sweeps/"+cfg.RunID)
+	writeStatus(w, http.StatusAccepted, sw.status())
+}
+
+// pruneSweeps forgets the sweeps finished before sweepRetention, and returns
+// the ID of the running sweep, if any. s.mu must be held.
+func (s *sweepServer) pruneSweeps() string {
+	var running string
+	for id, sw := range s.sweeps {
+		sw.mu.Lock()
+		if sw.state == sweepRunning {
+			running = id
+		} else if time.Since(sw.finished) > sweepRetention {
+			delete(s.sweeps, id)
+		}
+		sw.mu.Unlock()
+	}
+	return running
+}
+
+// sweepRequest is the JSON of the body of POST /sweeps: the fields of the
+// Config which a client may set, pointing into the config of the sweep. The
+// binaries, the wrapper, the environment, the storage and the paths are
+// always the ones of the flags of the server, because the API isn't
+// authenticated.
+type sweepRequest struct {
+	StudyName          *string
+	RunID              *string
+	Labels             *map[string]string
+	NTrials            *int
+	Concurrency        *int
+	Seed               *int64
+	DataSeed           *int64
+	Metric             *string
+	CreateIfMissing    *bool
+	FailIfExists       *bool
+	ResumeIncomplete   *bool
+	LatentLog2         *bool
+	LatentPenalty      *float64
+	AutoScale          *bool
+	Normalize          *bool
+	SelectFields       *bool
+	EtaDecayMin        *float64
+	EtaDecayMax        *float64
+	SamplerWindow      *int
+	Repeats            *int
+	RandomSplit        *float64
+	MaxMemoryMB        *float64
+	DivergenceRetry    *int
+	PenalizeDivergence *bool
+	TrialTimeout       *time.Duration
+	TimeoutAction      *string
+	PlausibleMin       *float64
+	PlausibleMax       *float64
+	RecordMetrics      *[]string
+	MinTrialsForBest   *int
+	TieBreak           *string
+}
+
+func newSweepRequest(cfg *Config) *sweepRequest {
+	return &sweepRequest{
+		StudyName:          &cfg.StudyName,
+		RunID:              &cfg.RunID,
+		Labels:             &cfg.Labels,
+		NTrials:            &cfg.NTrials,
+		Concurrency:        &cfg.Concurrency,
+		Seed:               &cfg.Seed,
+		DataSeed:           &cfg.DataSeed,
+		Metric:             &cfg.Metric,
+		CreateIfMissing:    &cfg.CreateIfMissing,
+		FailIfExists:       &cfg.FailIfExists,
+		ResumeIncomplete:   &cfg.ResumeIncomplete,
+		LatentLog2:         &cfg.LatentLog2,
+		LatentPenalty:      &cfg.LatentPenalty,
+		AutoScale:          &cfg.AutoScale,
+		Normalize:          &cfg.Normalize,
+		SelectFields:       &cfg.SelectFields,
+		EtaDecayMin:        &cfg.EtaDecayMin,
+		EtaDecayMax:        &cfg.EtaDecayMax,
+		SamplerWindow:      &cfg.SamplerWindow,
+		Repeats:            &cfg.Repeats,
+		RandomSplit:        &cfg.RandomSplit,
+		MaxMemoryMB:        &cfg.MaxMemoryMB,
+		DivergenceRetry:    &cfg.DivergenceRetry,
+		PenalizeDivergence: &cfg.PenalizeDivergence,
+		TrialTimeout:       &cfg.TrialTimeout,
+		TimeoutAction:      &cfg.TimeoutAction,
+		PlausibleMin:       &cfg.PlausibleMin,
+		PlausibleMax:       &cfg.PlausibleMax,
+		RecordMetrics:      &cfg.RecordMetrics,
+		MinTrialsForBest:   &cfg.MinTrialsForBest,
+		TieBreak:           &cfg.TieBreak,
+	}
+}
+
+// sweepConfig returns the config of the flags of the server overridden by the
+// sweepRequest in the body.
+func (s *sweepServer) sweepConfig(req *http.Request) (*Config, error) {
+	cfg, err := parseArgs(s.args)
+	if err != nil {
+		return nil, err
+	}
+	// each sweep has a new run ID unless the body gives one.
+	cfg.RunID = ""
+	dec := json.NewDecoder(req.Body)
+	dec.DisallowUnknownFields()
+	// the JSON is decoded into the fields of cfg which the pointers point to,
+	// except DataSeed, which follows the Seed of the body unless either the
+	// body or the flags give it.
+	body := newSweepRequest(cfg)
+	body.DataSeed = nil
+	if err = dec.Decode(body); err != nil {
+		return nil, fmt.Errorf("invalid config: %s", err)
+	}
+	if body.DataSeed != nil {
+		cfg.DataSeed = *body.DataSeed
+	} else if !cfg.dataSeedSet {
+		cfg.DataSeed = cfg.Seed
+	}
+	cfg.Serve = ""
+	// the server handles the signals for all the sweeps.
+	cfg.NoSignalHandler = true
+	if err = completeConfig(cfg); err != nil {
+		return nil, err
+	}
+	if cfg.GenSynthetic || cfg.SplitFolds > 0 || cfg.InitDB || cfg.ShowBest || cfg.Explain || cfg.Export != "" || cfg.ExportOptuna != "" ||
+		cfg.CompareStudy != "" || cfg.AnnotateTrials || cfg.Once || cfg.ParamsFromTrial >= 0 || cfg.Version {
+		return nil, errors.New("the config must be a sweep, not another mode")
+	}
+	return cfg, nil
+}
+
+// handleSweep returns the status of a sweep or cancels it.
+func (s *sweepServer) handleSweep(w http.ResponseWriter, req *http.Request) {
+	id := strings.TrimPrefix(req.URL.Path, "/sweeps/")
+	s.mu.Lock()
+	sw, ok := s.sweeps[id]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	switch req.Method {
+	case http.MethodGet:
+		st := sw.status()
+		sw.addProgress(&st)
+		writeStatus(w, http.StatusOK, st)
+	case http.MethodDelete:
+		sw.cancel()
+		log.Printf("canceling sweep %s", id)
+		writeStatus(w, http.StatusAccepted, sw.status())
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// status returns the state of the sweep.
+func (sw *sweep) status() sweepStatus {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	st := sweepStatus{
+		ID:      sw.cfg.RunID,
+		Study:   sw.cfg.StudyName,
+		State:   sw.state,
+		Started: sw.started,
+	}
+	if sw.err != nil {
+		st.Error = sw.err.Error()
+	}
+	if !sw.finished.IsZero() {
+		finished := sw.finished
+		st.Finished = &finished
+	}
+	return st
+}
+
+// addProgress adds the trials counted by state and the best trial in the
+// storage to the status, which are left out until the study is created.
+func (sw *sweep) addProgress(st *sweepStatus) {
+	study, db, err := loadExistingStudy(sw.cfg.DSN, sw.cfg.StudyName)
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	trials, err := study.GetTrials()
+	if err != nil {
+		return
+	}
+	st.Trials = make(map[string]int)
+	for _, t := range trials {
+		st.Trials[strings.ToLower(t.State.String())]++
+	}
+	if best, err := getBestResult(study, sw.cfg); err == nil {
+		st.Best = &best
+	} else if err != goptuna.ErrNoCompletedTrials {
+		log.Printf("failed to get the best trial of sweep %s: %s", sw.cfg.RunID, err)
+	}
+}
+
+func writeStatus(w http.ResponseWriter, code int, st sweepStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(st); err != nil {
+		log.Print("failed to write the response:", err)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// errTrainServerExited is returned when the ffm-train server exits while
+// running trials.
+var errTrainServerExited = errors.New("ffm-train server exited")
+
+// trainRequest is a line written to the stdin of the ffm-train server.
+// Args are the same command line arguments as running ffm-train per trial.
+// Cancel aborts the training of the earlier request of ID, like on
+// -trial-timeout, whose response is ignored.
+type trainRequest struct {
+	ID     int      `json:"id"`
+	Args   []string `json:"args,omitempty"`
+	Cancel bool     `json:"cancel,omitempty"`
+}
+
+// trainResponse is a line written to the stdout of the ffm-train server
+// after the training of the request finished.
+type trainResponse struct {
+	ID       int    `json:"id"`
+	ExitCode int    `json:"exit_code"`
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+}
+
+// trainServer talks with a persistent "ffm-train --server" process, which
+// libffm forks may provide to reuse the loaded dataset across trials. The
+// protocol is JSON lines of trainRequest and trainResponse over stdin and
+// stdout. Responses may come in any order, so trials can run concurrently.
+type trainServer struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+
+	mu      sync.Mutex
+	nextID  int
+	pending map[int]chan trainResponse
+}
+
+func startTrainServer(ctx context.Context, bin string, args, env []string) (*trainServer, error) {
+	cmd := exec.CommandContext(ctx, bin, args...)
+	cmd.Env = env
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	s := &trainServer{
+		cmd:     cmd,
+		stdin:   stdin,
+		pending: make(map[int]chan trainResponse, 8),
+	}
+	go s.readLoop(stdout)
+	return s, nil
+}
+
+func (s *trainServer) readLoop(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var resp trainResponse
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			log.Print("ffm-train server wrote an invalid response:", err)
+			continue
+		}
+
+		s.mu.Lock()
+		ch, ok := s.pending[resp.ID]
+		delete(s.pending, resp.ID)
+		s.mu.Unlock()
+		if ok {
+			ch <- resp
+		}
+	}
+
+	// the server exited, so no response will come anymore.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ch := range s.pending {
+		close(ch)
+	}
+	s.pending = nil
+}
+
+// Train sends the arguments to the server and waits for the training.
+func (s *trainServer) Train(ctx context.Context, args []string) (trainResponse, error) {
+	ch := make(chan trainResponse, 1)
+
+	s.mu.Lock()
+	if s.pending == nil {
+		s.mu.Unlock()
+		return trainResponse{}, errTrainServerExited
+	}
+	id := s.nextID
+	s.nextID++
+	b, err := json.Marshal(trainRequest{ID: id, Args: args})
+	if err == nil {
+		_, err = s.stdin.Write(append(b, '\n'))
+	}
+	if err != nil {
+		s.mu.Unlock()
+		return trainResponse{}, err
+	}
+	s.pending[id] = ch
+	s.mu.Unlock()
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return trainResponse{}, errTrainServerExited
+		}
+		return resp, nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		if s.pending != nil {
+			delete(s.pending, id)
+			// the server would train it until the end otherwise, taking
+			// the cores of the next trials.
+			if err := s.cancel(id); err != nil {
+				log.Printf("failed to cancel the request %d of the ffm-train server: %s", id, err)
+			}
+		}
+		s.mu.Unlock()
+		return trainResponse{}, ctx.Err()
+	}
+}
+
+// cancel sends the cancel request of id. s.mu must be held.
+func (s *trainServer) cancel(id int) error {
+	b, err := json.Marshal(trainRequest{ID: id, Cancel: true})
+	if err != nil {
+		return err
+	}
+	_, err = s.stdin.Write(append(b, '\n'))
+	return err
+}
+
+// Close stops the server by closing its stdin and waits for the exit.
+func (s *trainServer) Close() error {
+	if err := s.stdin.Close(); err != nil {
+		return err
+	}
+	return s.cmd.Wait()
+}
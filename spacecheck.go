@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/c-bata/goptuna"
+)
+
+// The strictness of -search-space-check.
+const (
+	searchSpaceCheckOff   = "off"
+	searchSpaceCheckWarn  = "warn"
+	searchSpaceCheckError = "error"
+)
+
+// distributions returns the distributions which the objective currently
+// suggests, by param name. train_seed is left out because its range is the
+// seed of each trial.
+func (r *runner) distributions() map[string]interface{} {
+	dists := make(map[string]interface{}, 4+len(r.fields))
+	if r.cfg.LatentLog2 {
+		dists["latent"] = goptuna.CategoricalDistribution{Choices: latentChoices}
+	} else {
+		dists["latent"] = goptuna.IntUniformDistribution{Low: 1, High: 16}
+	}
+	for _, field := range r.fields {
+		dists[fieldParamName(field)] = goptuna.CategoricalDistribution{Choices: fieldChoices}
+	}
+	if r.cfg.EtaDecayFlag != "" {
+		dists[etaDecayParam] = goptuna.UniformDistribution{Low: r.cfg.EtaDecayMin, High: r.cfg.EtaDecayMax}
+	}
+	if r.buckets == nil {
+		dists["lambda"] = goptuna.LogUniformDistribution{Low: r.space.lambdaLow, High: r.space.lambdaHigh}
+		dists["eta"] = goptuna.LogUniformDistribution{Low: r.space.etaLow, High: r.space.etaHigh}
+		return dists
+	}
+	for _, b := range r.buckets {
+		dists[b.paramName("lambda")] = goptuna.LogUniformDistribution{Low: b.space.lambdaLow, High: b.space.lambdaHigh}
+		dists[b.paramName("eta")] = goptuna.LogUniformDistribution{Low: b.space.etaLow, High: b.space.etaHigh}
+	}
+	return dists
+}
+
+// checkSearchSpace returns the number of the stored trials with a param
+// outside its current distribution, by param name: the value is out of the
+// range, or the param was sampled from another kind of distribution, like an
+// integer latent before -latent-log2. The sampler still builds its model on
+// such values although it would never propose them now. Params which are no
+// longer suggested are ignored because the sampler never looks them up.
+func checkSearchSpace(study *goptuna.Study, dists map[string]interface{}) (map[string]int, error) {
+	trials, err := study.GetTrials()
+	if err != nil {
+		return nil, err
+	}
+	outside := make(map[string]int, len(dists))
+	for _, t := range trials {
+		for name, v := range t.Params {
+			d, ok := dists[name]
+			if !ok {
+				continue
+			}
+			if reflect.TypeOf(t.Distributions[name]) != reflect.TypeOf(d) || !distributionContains(d, v) {
+				outside[name]++
+			}
+		}
+	}
+	return outside, nil
+}
+
+// distributionContains reports whether the external value of a param is in
+// the distribution.
+func distributionContains(d interface{}, v interface{}) bool {
+	switch d := d.(type) {
+	case goptuna.UniformDistribution:
+		x, ok := v.(float64)
+		return ok && d.Low <= x && x <= d.High
+	case goptuna.LogUniformDistribution:
+		x, ok := v.(float64)
+		return ok && d.Low <= x && x <= d.High
+	case goptuna.IntUniformDistribution:
+		x, ok := v.(int)
+		return ok && d.Low <= x && x <= d.High
+	case goptuna.CategoricalDistribution:
+		x, ok := v.(string)
+		if !ok {
+			return false
+		}
+		for _, c := range d.Choices {
+			if c == x {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// formatOutside formats the counts of checkSearchSpace like
+// "eta: 3 trials, lambda: 1 trials".
+func formatOutside(outside map[string]int) string {
+	names := make([]string, 0, len(outside))
+	for name := range outside {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s: %d trials", name, outside[name])
+	}
+	return strings.Join(parts, ", ")
+}
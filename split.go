@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// splitSeedSalt separates the seeds of -random-split from the ones of the
+// sampler and -train-seed-flag, which are drawn per trial number too.
+const splitSeedSalt = 0x5bd1e995
+
+// splitSeed returns the seed of the split of the trial.
+func splitSeed(seed int64, number int) int64 {
+	return trialSeed(seed, number) ^ splitSeedSalt
+}
+
+// evalDataPath returns the data whose labels are checked before the trials:
+// the validation data, or the whole data which -random-split draws from.
+func evalDataPath(cfg *Config) string {
+	if cfg.RandomSplit > 0 {
+		return cfg.TrainPath
+	}
+	return cfg.ValidPath
+}
+
+// randomSplit writes a split of the data at path to the training and the
+// validation data of the trial under ./data/optuna: each example is held out
+// for validation with the probability ratio, drawn from the seed. The files
+// are removed by removeSplit.
+func randomSplit(path string, ratio float64, seed int64, number int) (dataPaths, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return dataPaths{}, err
+	}
+	defer in.Close()
+
+	data := splitPaths(path, number)
+	if err = os.MkdirAll("./data/optuna", 0755); err != nil {
+		return dataPaths{}, err
+	}
+	train, err := os.Create(data.train)
+	if err != nil {
+		return dataPaths{}, err
+	}
+	defer train.Close()
+	valid, err := os.Create(data.valid)
+	if err != nil {
+		removeSplit(data)
+		return dataPaths{}, err
+	}
+	defer valid.Close()
+
+	rng := rand.New(rand.NewSource(seed))
+	tw, vw := bufio.NewWriter(train), bufio.NewWriter(valid)
+	var nTrain, nValid int
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		w := tw
+		if rng.Float64() < ratio {
+			w, nValid = vw, nValid+1
+		} else {
+			nTrain++
+		}
+		w.Write(line)
+		w.WriteByte('\n')
+	}
+	err = scanner.Err()
+	if err == nil {
+		err = tw.Flush()
+	}
+	if err == nil {
+		err = vw.Flush()
+	}
+	if err == nil && (nTrain == 0 || nValid == 0) {
+		err = errors.New("the split left no training or no validation examples")
+	}
+	if err != nil {
+		removeSplit(data)
+		return dataPaths{}, err
+	}
+	return data, nil
+}
+
+// splitPaths returns the files of randomSplit of the data at path for the
+// trial.
+func splitPaths(path string, number int) dataPaths {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(filepath.Base(path), ext)
+	return dataPaths{
+		train: filepath.Join("./data/optuna", fmt.Sprintf("%s-split-%d-train%s", base, number, ext)),
+		valid: filepath.Join("./data/optuna", fmt.Sprintf("%s-split-%d-valid%s", base, number, ext)),
+	}
+}
+
+// removeSplit removes the files of randomSplit.
+func removeSplit(data dataPaths) {
+	os.Remove(data.train)
+	os.Remove(data.valid)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/c-bata/goptuna"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite" // sqlite3 dialect
+)
+
+// openDB opens the SQLite3 database at dsn.
+func openDB(dsn string) (*gorm.DB, error) {
+	db, err := gorm.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.DB().SetMaxOpenConns(1)
+	return db, nil
+}
+
+// studyExists reports whether the study is stored. The error returned by
+// GetStudyIDFromName differs between storage backends (gorm.ErrRecordNotFound,
+// goptuna.ErrNotFound, ...), so a failed lookup is confirmed by scanning the
+// study summaries instead of inspecting the error.
+func studyExists(storage goptuna.Storage, name string) (bool, error) {
+	if _, err := storage.GetStudyIDFromName(name); err == nil {
+		return true, nil
+	}
+
+	summaries, err := storage.GetAllStudySummaries()
+	if err != nil {
+		return false, err
+	}
+	for i := range summaries {
+		if summaries[i].Name == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// loadOrCreateStudy loads the configured study, or creates it if it is missing
+// and cfg.CreateIfMissing is set.
+func loadOrCreateStudy(
+	cfg *Config,
+	storage goptuna.Storage,
+	opts ...goptuna.StudyOption,
+) (*goptuna.Study, error) {
+	exists, err := studyExists(storage, cfg.StudyName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up study: %s", err)
+	}
+
+	opts = append([]goptuna.StudyOption{goptuna.StudyOptionStorage(storage)}, opts...)
+	if exists {
+		if cfg.FailIfExists {
+			return nil, fmt.Errorf("study %q already exists", cfg.StudyName)
+		}
+		return goptuna.LoadStudy(cfg.StudyName, opts...)
+	}
+
+	if !cfg.CreateIfMissing {
+		return nil, fmt.Errorf("study %q doesn't exist", cfg.StudyName)
+	}
+	return goptuna.CreateStudy(cfg.StudyName, opts...)
+}
+
+// setLabels stores the labels as user attrs of the study. The storage can't
+// overwrite attrs, so a label which is already set to another value is an error.
+func setLabels(study *goptuna.Study, labels map[string]string) error {
+	attrs, err := study.GetUserAttrs()
+	if err != nil {
+		return err
+	}
+	for k, v := range labels {
+		if old, ok := attrs[k]; ok {
+			if old != v {
+				return fmt.Errorf("label %q is already set to %q", k, old)
+			}
+			continue
+		}
+		if err = study.SetUserAttr(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setStudySystemAttrIfMissing stores the system attr of the study unless it
+// is already set, because the storage can't overwrite attrs. It returns the
+// value stored before, or an empty string if the attr is newly set.
+func setStudySystemAttrIfMissing(study *goptuna.Study, key, value string) (string, error) {
+	attrs, err := study.GetSystemAttrs()
+	if err != nil {
+		return "", err
+	}
+	if old, ok := attrs[key]; ok {
+		return old, nil
+	}
+	return "", study.SetSystemAttr(key, value)
+}
+
+// objectiveAttrKey is a system attr of the study holding the metric it
+// optimizes, and "normalized" too with -normalize.
+const objectiveAttrKey = "goptuna-libffm:objective"
+
+// checkStudyObjective returns an error if the study was created optimizing
+// another objective or in another direction than the config, because the
+// values of the resumed trials wouldn't compare with the new ones. The
+// objective is stored if the study has none, like the studies before it was
+// stored.
+func checkStudyObjective(study *goptuna.Study, cfg *Config) error {
+	objective := cfg.Metric
+	if cfg.Normalize {
+		objective += " normalized"
+	}
+	// the direction is checked first, so that a mismatch isn't stored as the
+	// objective of an old study.
+	if direction := objectiveDirection(cfg); study.Direction() != direction {
+		return fmt.Errorf("study %q is to %s, but %s is to %s: the values of its trials don't compare, so use another -study",
+			cfg.StudyName, study.Direction(), objective, direction)
+	}
+	old, err := setStudySystemAttrIfMissing(study, objectiveAttrKey, objective)
+	if err != nil {
+		return err
+	}
+	if old != "" && old != objective {
+		return fmt.Errorf("study %q optimizes %s, not %s of the flags: the values of its trials don't compare, so use another -study",
+			cfg.StudyName, old, objective)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/c-bata/goptuna"
+)
+
+// studySummary is the file of -summary-json, which serving code reads for
+// the best params without the storage.
+type studySummary struct {
+	Study string  `json:"study"`
+	Trial int     `json:"trial"`
+	Value float64 `json:"value"`
+	// Params are the params which ffm-train ran with.
+	Params        map[string]interface{} `json:"params"`
+	BestIteration int                    `json:"best_iteration"`
+}
+
+// summaryWriter rewrites the summary file whenever the best trial changes.
+type summaryWriter struct {
+	cfg   *Config
+	study *goptuna.Study
+	path  string
+
+	mu sync.Mutex
+	// written is the number of the trial written last, or -1.
+	written int
+}
+
+func newSummaryWriter(cfg *Config, study *goptuna.Study) *summaryWriter {
+	return &summaryWriter{
+		cfg:     cfg,
+		study:   study,
+		path:    cfg.SummaryJSON,
+		written: -1,
+	}
+}
+
+// TrialFinished writes the summary if the best trial has changed.
+func (w *summaryWriter) TrialFinished() {
+	if w == nil {
+		return
+	}
+	best, err := getBestTrial(w.study, bestFilter(w.cfg), w.cfg.TieBreak)
+	if err != nil {
+		if err != goptuna.ErrNoCompletedTrials {
+			log.Print("failed to get the best trial to summarize:", err)
+		}
+		return
+	}
+	if err = w.Write(best); err != nil {
+		log.Print("failed to write the study summary:", err)
+	}
+}
+
+// Write writes the summary of the trial as the best unless it's written
+// already. The file is renamed into place, so readers never see a partial
+// file.
+func (w *summaryWriter) Write(best goptuna.FrozenTrial) error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if best.Number == w.written {
+		return nil
+	}
+	params, err := trialParams(best)
+	if err != nil {
+		return err
+	}
+	iteration, _ := strconv.Atoi(best.UserAttrs["best_iteration"])
+	b, err := json.MarshalIndent(studySummary{
+		Study:         w.cfg.StudyName,
+		Trial:         best.Number,
+		Value:         best.Value,
+		Params:        params,
+		BestIteration: iteration,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(w.path), filepath.Base(w.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	// readable like ioutil.WriteFile, unlike a temporary file.
+	if err = tmp.Chmod(0644); err == nil {
+		_, err = tmp.Write(b)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), w.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	w.written = best.Number
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+)
+
+// syntheticLatent is the dimension of the latent vectors of the features of
+// the synthetic data.
+const syntheticLatent = 4
+
+// genSynthetic writes synthetic libffm data to the training and the
+// validation data paths: each example has a feature per field, and the label
+// is drawn from the logistic of the weights of the features plus the field-
+// aware interactions of their latent vectors, so that FFM can learn the
+// signal. A quarter of cfg.SyntheticExamples more are written as the
+// validation data. The same seed writes the same data. Existing files are
+// never overwritten. ./data/optuna is created too, so that a study runs on
+// the data right away.
+func genSynthetic(cfg *Config) error {
+	for _, path := range []string{cfg.TrainPath, cfg.ValidPath} {
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("%s already exists", path)
+		}
+	}
+	if err := os.MkdirAll("./data/optuna", 0755); err != nil {
+		return err
+	}
+	fields, features := cfg.SyntheticFields, cfg.SyntheticFeatures
+	rng := rand.New(rand.NewSource(cfg.DataSeed))
+	weights := make([]float64, fields*features)
+	// latent[(i*fields+f)*syntheticLatent:] is the vector of feature i for
+	// field f, as in FFM.
+	latent := make([]float64, fields*features*fields*syntheticLatent)
+	for i := range weights {
+		weights[i] = rng.NormFloat64()
+	}
+	for i := range latent {
+		latent[i] = rng.NormFloat64() / math.Sqrt(syntheticLatent)
+	}
+
+	for _, f := range []struct {
+		path     string
+		examples int
+	}{
+		{cfg.TrainPath, cfg.SyntheticExamples},
+		{cfg.ValidPath, (cfg.SyntheticExamples + 3) / 4},
+	} {
+		if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+			return err
+		}
+		out, err := os.Create(f.path)
+		if err != nil {
+			return err
+		}
+		w := bufio.NewWriter(out)
+		active := make([]int, fields)
+		for n := 0; n < f.examples; n++ {
+			score := 0.0
+			for field := range active {
+				active[field] = field*features + rng.Intn(features)
+				score += weights[active[field]]
+			}
+			for a := 0; a < fields; a++ {
+				for b := a + 1; b < fields; b++ {
+					va := latent[(active[a]*fields+b)*syntheticLatent:]
+					vb := latent[(active[b]*fields+a)*syntheticLatent:]
+					for k := 0; k < syntheticLatent; k++ {
+						score += va[k] * vb[k]
+					}
+				}
+			}
+			label := 0
+			if rng.Float64() < 1/(1+math.Exp(-score)) {
+				label = 1
+			}
+			fmt.Fprintf(w, "%d", label)
+			for field, feature := range active {
+				fmt.Fprintf(w, " %d:%d:1", field, feature)
+			}
+			w.WriteByte('\n')
+		}
+		err = w.Flush()
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("wrote %d examples to %s\n", f.examples, f.path)
+	}
+	return nil
+}
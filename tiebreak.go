@@ -0,0 +1,66 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/c-bata/goptuna"
+)
+
+// The policies of -tie-break, which select the best of the trials with the
+// same best value.
+const (
+	// tieBreakFirst selects the earliest trial.
+	tieBreakFirst = "first"
+	// tieBreakLatent selects the smallest latent, the simplest model.
+	tieBreakLatent = "latent"
+	// tieBreakIteration selects the smallest best_iteration, the cheapest
+	// model to retrain.
+	tieBreakIteration = "iteration"
+	// tieBreakFail selects the earliest trial like tieBreakFirst, but the
+	// sweep fails after reporting the ties.
+	tieBreakFail = "fail"
+)
+
+// tieBreakKey returns the key of the trial by the policy, smaller is
+// preferred. The trials without the param or the attr come last.
+func tieBreakKey(t goptuna.FrozenTrial, policy string) int {
+	switch policy {
+	case tieBreakLatent:
+		params, err := trialParams(t)
+		if err != nil {
+			return math.MaxInt32
+		}
+		if latent, err := intParam(params, "latent"); err == nil {
+			return latent
+		}
+	case tieBreakIteration:
+		if n, err := strconv.Atoi(t.UserAttrs["best_iteration"]); err == nil {
+			return n
+		}
+	default:
+		return 0
+	}
+	return math.MaxInt32
+}
+
+// tiedTrials returns the sorted numbers of the trials which can be the best
+// with the same value as best, including best, or nil if there's no tie.
+func tiedTrials(study *goptuna.Study, filter func(goptuna.FrozenTrial) bool, best goptuna.FrozenTrial) ([]int, error) {
+	candidates, err := bestCandidates(study, filter)
+	if err != nil {
+		return nil, err
+	}
+	var tied []int
+	for _, t := range candidates {
+		if t.Value == best.Value {
+			tied = append(tied, t.Number)
+		}
+	}
+	if len(tied) < 2 {
+		return nil, nil
+	}
+	sort.Ints(tied)
+	return tied, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/c-bata/goptuna"
+)
+
+// The actions of -timeout-action on a trial which runs over -trial-timeout.
+const (
+	timeoutActionFail  = "fail"
+	timeoutActionPrune = "prune"
+)
+
+// bestIntermediate returns the best va_logloss of the iterations which
+// ffm-train printed to stdout, and its iteration, or false if it printed
+// none, e.g. without the validation data.
+func bestIntermediate(stdout string) (int, float64, bool) {
+	column := -1
+	bestIteration, best := 0, math.Inf(1)
+	for _, line := range strings.Split(stdout, "\n") {
+		fields := strings.Fields(line)
+		if column < 0 {
+			for i, f := range fields {
+				if f == "va_logloss" {
+					column = i
+				}
+			}
+			continue
+		}
+		if len(fields) <= column {
+			continue
+		}
+		iteration, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		loss, err := strconv.ParseFloat(fields[column], 64)
+		if err != nil || isDiverged(loss) {
+			continue
+		}
+		if loss < best {
+			bestIteration, best = iteration, loss
+		}
+	}
+	return bestIteration, best, !math.IsInf(best, 1)
+}
+
+// pruneTimedOut prunes a trial which ran over -trial-timeout with the value
+// which the Trainer returned with the timeout, like the best va_logloss
+// printed by ffm-train, reported as the intermediate value of the
+// "best_iteration" attr, so that the sampler learns from the pruned trial.
+// The trial fails with err if the Trainer returned no value.
+func (r *runner) pruneTimedOut(trial goptuna.Trial, value float64, attrs map[string]string, latent int, err error) (float64, error) {
+	if math.IsNaN(value) {
+		return failedValue, err
+	}
+	iteration, _ := strconv.Atoi(attrs["best_iteration"])
+	r.setUserAttr(trial, "pruned_by", fmt.Sprintf("timeout at iteration %d", iteration))
+	value = r.objectiveValue(trial, value, latent)
+	if err = trial.Report(value, iteration); err != nil {
+		return failedValue, categorize(failureStorage, fmt.Errorf("failed to report the value before the timeout: %s", err))
+	}
+	return value, goptuna.ErrTrialPruned
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// Trainer trains a model with the params of a trial and evaluates the metric
+// which the study optimizes, so that a backend other than libffm, like
+// xlearn, runs under the same study, storage and signal handling. The params
+// are the effective ones of the trial ("lambda", "eta", "latent" and the
+// others of the flags), plus "train_seed" with -train-seed-flag. The attrs
+// are stored as the user attrs of the trial, even with an error, and
+// goptuna.ErrTrialPruned prunes the trial. Train is called concurrently by
+// the workers of -concurrency.
+//
+// ctx is canceled on -trial-timeout. A Trainer may return the best metric
+// until then with the error, and its step as the "best_iteration" attr, which
+// -timeout-action prune reports, or NaN to fail the trial.
+type Trainer interface {
+	Train(ctx context.Context, params map[string]interface{}, paths TrainPaths) (metric float64, attrs map[string]string, err error)
+}
+
+// TrainPaths are the data of a trial, which may be the copies of
+// -random-split or -select-fields.
+type TrainPaths struct {
+	// Trial is the number of the trial, to name the files it writes.
+	Trial int
+	// Train is the training data, and Valid is the validation data which the
+	// metric is evaluated on.
+	Train string
+	Valid string
+}
+
+// trainer returns Config.Trainer, or libffm by default.
+func (r *runner) trainer() Trainer {
+	if r.cfg.Trainer != nil {
+		return r.cfg.Trainer
+	}
+	return libffmTrainer{r: r}
+}
+
+// checkTrainer returns an error if a flag which runs ffm-train by itself is
+// set with Config.Trainer.
+func checkTrainer(cfg *Config) error {
+	if cfg.Trainer == nil {
+		return nil
+	}
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"-probe-json-meta", cfg.ProbeJSONMeta},
+		{"-precompute-bin", cfg.PrecomputeBin},
+		{"-warm-pool", cfg.WarmPool},
+		{"-reeval-top-k", cfg.ReevalTopK > 0},
+		{"-final-model", cfg.FinalModel != ""},
+		{"-autosave-every", cfg.AutosaveEvery > 0},
+		{"-gen-script", cfg.GenScript != ""},
+	} {
+		if f.set {
+			return fmt.Errorf("%s runs ffm-train, so it can't be used with Config.Trainer", f.name)
+		}
+	}
+	return nil
+}
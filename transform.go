@@ -0,0 +1,41 @@
+package main
+
+import (
+	"log"
+	"math"
+)
+
+// valueTransformProbes are the metric values which Config.ValueTransform is
+// checked at, spanning the usual range of the metrics.
+var valueTransformProbes = []float64{0, 0.001, 0.01, 0.05, 0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 0.95, 0.99, 1, 2, 5, 10}
+
+// normalizedProbes are the probes added for -normalize, which goes negative
+// for the trials worse than the baseline.
+var normalizedProbes = []float64{-10, -5, -2, -1, -0.5, -0.1}
+
+// checkValueTransform warns if Config.ValueTransform isn't increasing at the
+// probes, because a transform which isn't turns the direction of the study
+// around for some of the trials.
+func checkValueTransform(cfg *Config) {
+	if cfg.ValueTransform == nil {
+		return
+	}
+	probes := valueTransformProbes
+	if cfg.Normalize {
+		probes = append(append([]float64{}, normalizedProbes...), probes...)
+	}
+	prev := math.NaN()
+	for i, x := range probes {
+		y := cfg.ValueTransform(x)
+		if math.IsNaN(y) {
+			log.Printf("WARNING: ValueTransform(%g) is NaN, so such trials would fail", x)
+			return
+		}
+		if i > 0 && y <= prev {
+			log.Printf("WARNING: ValueTransform isn't increasing: ValueTransform(%g)=%g but ValueTransform(%g)=%g, "+
+				"so the trials may not be ranked as their metric", probes[i-1], prev, x, y)
+			return
+		}
+		prev = y
+	}
+}
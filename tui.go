@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c-bata/goptuna"
+)
+
+const (
+	// dashboardInterval is the refresh interval of -tui.
+	dashboardInterval = time.Second
+	// dashboardLogLines is the number of the last log lines shown by -tui.
+	dashboardLogLines = 8
+	// sparklineWidth is the number of the last trials in the sparkline.
+	sparklineWidth = 60
+)
+
+var sparkTicks = []rune("▁▂▃▄▅▆▇█")
+
+// isTerminal reports whether f is a terminal rather than a file or a pipe.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// dashboard redraws the progress of the study on the terminal periodically.
+// It takes over the log output while it's running, and shows the last lines
+// below the progress instead of scrolling it away.
+type dashboard struct {
+	cfg   *Config
+	study *goptuna.Study
+	out   io.Writer
+	logs  *logTail
+	start time.Time
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+func newDashboard(cfg *Config, study *goptuna.Study, out io.Writer, logs *logTail) *dashboard {
+	d := &dashboard{
+		cfg:   cfg,
+		study: study,
+		out:   out,
+		logs:  logs,
+		start: time.Now(),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go d.loop()
+	return d
+}
+
+func (d *dashboard) loop() {
+	defer close(d.done)
+	ticker := time.NewTicker(dashboardInterval)
+	defer ticker.Stop()
+	for {
+		d.render()
+		select {
+		case <-ticker.C:
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// Close stops refreshing after drawing the final state.
+func (d *dashboard) Close() {
+	close(d.stop)
+	<-d.done
+	d.render()
+}
+
+func (d *dashboard) render() {
+	buf := &bytes.Buffer{}
+	buf.WriteString("\x1b[H\x1b[2J") // move to the top-left and clear the screen
+	fmt.Fprintf(buf, "study %s  run %s  elapsed %s\n\n",
+		d.cfg.StudyName, d.cfg.RunID, time.Since(d.start).Truncate(time.Second))
+
+	trials, err := d.study.GetTrials()
+	if err != nil {
+		fmt.Fprintf(buf, "failed to get trials: %s\n", err)
+		d.out.Write(buf.Bytes())
+		return
+	}
+	sort.Slice(trials, func(i, j int) bool {
+		return trials[i].Number < trials[j].Number
+	})
+
+	counts := make(map[goptuna.TrialState]int, 4)
+	var running []goptuna.FrozenTrial
+	var bests []float64
+	best := math.NaN()
+	maximize := d.study.Direction() == goptuna.StudyDirectionMaximize
+	for _, t := range trials {
+		counts[t.State]++
+		switch t.State {
+		case goptuna.TrialStateRunning:
+			running = append(running, t)
+		case goptuna.TrialStateComplete:
+			if t.UserAttrs[divergedAttrKey] != "" {
+				continue
+			}
+			if math.IsNaN(best) || (maximize && t.Value > best) || (!maximize && t.Value < best) {
+				best = t.Value
+			}
+			bests = append(bests, best)
+		}
+	}
+	fmt.Fprintf(buf, "complete %d  pruned %d  failed %d  running %d\n",
+		counts[goptuna.TrialStateComplete], counts[goptuna.TrialStatePruned],
+		counts[goptuna.TrialStateFail], counts[goptuna.TrialStateRunning])
+	if len(bests) > 0 {
+		fmt.Fprintf(buf, "best %.6g  %s\n", best, sparkline(bests, sparklineWidth))
+	} else {
+		buf.WriteString("best -\n")
+	}
+
+	fmt.Fprintf(buf, "\nworkers (%d running):\n", len(running))
+	for _, t := range running {
+		params, err := trialParams(t)
+		if err != nil {
+			params = t.Params
+		}
+		fmt.Fprintf(buf, "  trial %-5d lambda=%s eta=%s latent=%s\n", t.Number,
+			shortParam(params, "lambda"), shortParam(params, "eta"), shortParam(params, "latent"))
+	}
+
+	buf.WriteString("\nlog:\n")
+	for _, l := range d.logs.Lines() {
+		buf.WriteString("  " + l + "\n")
+	}
+	d.out.Write(buf.Bytes())
+}
+
+// shortParam formats the param of a running trial, which is "-" until it's
+// sampled.
+func shortParam(params map[string]interface{}, name string) string {
+	if v, ok := params[name].(float64); ok {
+		return fmt.Sprintf("%.4g", v)
+	}
+	return formatParam(params, name)
+}
+
+// sparkline draws the last width values scaled between their min and max.
+func sparkline(values []float64, width int) string {
+	if len(values) > width {
+		values = values[len(values)-width:]
+	}
+	low, high := values[0], values[0]
+	for _, v := range values {
+		low, high = math.Min(low, v), math.Max(high, v)
+	}
+	runes := make([]rune, len(values))
+	for i, v := range values {
+		level := 0
+		if high > low {
+			level = int((v - low) / (high - low) * float64(len(sparkTicks)-1))
+		}
+		runes[i] = sparkTicks[level]
+	}
+	return string(runes)
+}
+
+// logTail keeps the last lines written to it.
+type logTail struct {
+	mu      sync.Mutex
+	lines   []string
+	partial []byte
+}
+
+func (l *logTail) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.partial = append(l.partial, p...)
+	for {
+		i := bytes.IndexByte(l.partial, '\n')
+		if i < 0 {
+			break
+		}
+		l.lines = append(l.lines, strings.TrimRight(string(l.partial[:i]), "\r"))
+		l.partial = l.partial[i+1:]
+	}
+	if len(l.lines) > dashboardLogLines {
+		l.lines = l.lines[len(l.lines)-dashboardLogLines:]
+	}
+	return len(p), nil
+}
+
+// Lines returns the last lines.
+func (l *logTail) Lines() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string{}, l.lines...)
+}
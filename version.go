@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// version is the version of goptuna-libffm, which releases set by
+// -ldflags "-X main.version=v1.2.3". Otherwise it's the module version.
+var version = ""
+
+// versionInfo is the versions of goptuna-libffm and what it runs with.
+type versionInfo struct {
+	Build   string
+	Goptuna string
+	Libffm  string
+}
+
+func (v versionInfo) String() string {
+	return fmt.Sprintf("goptuna-libffm %s\ngoptuna %s\nlibffm %s", v.Build, v.Goptuna, v.Libffm)
+}
+
+// getVersionInfo returns the versions. The version of libffm is the first
+// line of "ffm-train --version" when it's supported, with the hash of the
+// binary to tell rebuilds of the same version apart.
+func getVersionInfo(ctx context.Context, cfg *Config) versionInfo {
+	v := versionInfo{Build: version, Goptuna: "unknown"}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if v.Build == "" {
+			v.Build = info.Main.Version
+		}
+		for _, dep := range info.Deps {
+			if dep.Path != "github.com/c-bata/goptuna" {
+				continue
+			}
+			if dep.Replace != nil {
+				dep = dep.Replace
+			}
+			v.Goptuna = dep.Version
+		}
+	}
+	if v.Build == "" {
+		v.Build = "unknown"
+	}
+	v.Build += " (" + runtime.Version() + ")"
+	v.Libffm = libffmVersion(ctx, cfg.TrainBin)
+	return v
+}
+
+func libffmVersion(ctx context.Context, bin string) string {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	version := "unknown"
+	if out, err := exec.CommandContext(ctx, bin, "--version").Output(); err == nil {
+		if line, _ := bufio.NewReader(bytes.NewReader(out)).ReadString('\n'); strings.TrimSpace(line) != "" {
+			version = strings.TrimSpace(line)
+		}
+	}
+	if p, err := exec.LookPath(bin); err == nil {
+		bin = p
+	}
+	hash, err := fileSHA256(bin)
+	if err != nil {
+		return version + " (" + err.Error() + ")"
+	}
+	return version + " (sha256 " + hash[:12] + ")"
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+)
+
+// warmCachePaths returns the data which ffm-train reads in each trial: the
+// training and the validation data, or their binary versions with
+// -precompute-bin, and only the training data with -random-split.
+func warmCachePaths(cfg *Config) []string {
+	paths := []string{cfg.TrainPath}
+	if cfg.RandomSplit == 0 {
+		paths = append(paths, cfg.ValidPath)
+	}
+	if cfg.PrecomputeBin {
+		for i, p := range paths {
+			paths[i] = binPath(p)
+		}
+	}
+	return paths
+}
+
+// warmPageCache reads the files sequentially once, so that the first trials
+// read them from the OS page cache like the rest instead of from the disk,
+// which would make them slower. It returns the number of the bytes read.
+func warmPageCache(ctx context.Context, paths []string) (int64, error) {
+	buf := make([]byte, 1<<20)
+	var total int64
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			return total, err
+		}
+		for {
+			if err = ctx.Err(); err != nil {
+				break
+			}
+			var n int
+			n, err = f.Read(buf)
+			total += int64(n)
+			if err != nil {
+				break
+			}
+		}
+		f.Close()
+		if err != io.EOF {
+			return total, err
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+)
+
+// loadWarmStart reads a JSON array of param maps to evaluate first. Every
+// entry is validated against the search space, so that a typo is reported
+// instead of silently falling back to the sampler.
+func loadWarmStart(path string, space searchSpace, cfg *Config) ([]map[string]interface{}, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []map[string]interface{}
+	if err = json.Unmarshal(b, &entries); err != nil {
+		return nil, fmt.Errorf("%s is not a JSON array of objects: %s", path, err)
+	}
+	for i := range entries {
+		if err = space.validate(entries[i], cfg); err != nil {
+			return nil, fmt.Errorf("entry %d of %s: %s", i, path, err)
+		}
+	}
+	return entries, nil
+}
+
+// validate checks that params has every param of the search space with the
+// right type and range. Integer params decoded from JSON are converted to int
+// in place.
+func (s searchSpace) validate(params map[string]interface{}, cfg *Config) error {
+	expected := map[string]bool{"lambda": true, "eta": true, "latent": true}
+	if cfg.TrainSeedFlag != "" {
+		expected["train_seed"] = true
+	}
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !expected[k] {
+			return fmt.Errorf("unknown param %q", k)
+		}
+	}
+	for _, k := range []string{"lambda", "eta", "latent", "train_seed"} {
+		if _, ok := params[k]; !ok && expected[k] {
+			return fmt.Errorf("param %q is missing", k)
+		}
+	}
+
+	if err := checkFloat(params, "lambda", s.lambdaLow, s.lambdaHigh); err != nil {
+		return err
+	}
+	if err := checkFloat(params, "eta", s.etaLow, s.etaHigh); err != nil {
+		return err
+	}
+	latent, err := checkInt(params, "latent")
+	if err != nil {
+		return err
+	}
+	if cfg.LatentLog2 {
+		if !containsString(latentChoices, strconv.Itoa(latent)) {
+			return fmt.Errorf("param \"latent\" must be one of %v with -latent-log2, got %d", latentChoices, latent)
+		}
+	} else if latent < 1 || latent > 16 {
+		return fmt.Errorf("param \"latent\" is out of range [1, 16]: %d", latent)
+	}
+	if expected["train_seed"] {
+		if _, err = checkInt(params, "train_seed"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkFloat(params map[string]interface{}, name string, low, high float64) error {
+	v, ok := params[name].(float64)
+	if !ok {
+		return fmt.Errorf("param %q must be a number, got %#v", name, params[name])
+	}
+	if v < low || v > high {
+		return fmt.Errorf("param %q is out of range [%g, %g]: %g", name, low, high, v)
+	}
+	return nil
+}
+
+func checkInt(params map[string]interface{}, name string) (int, error) {
+	v, ok := params[name].(float64)
+	if !ok || v != float64(int(v)) {
+		return 0, fmt.Errorf("param %q must be an integer, got %#v", name, params[name])
+	}
+	params[name] = int(v)
+	return int(v), nil
+}
+
+func containsString(list []string, s string) bool {
+	for i := range list {
+		if list[i] == s {
+			return true
+		}
+	}
+	return false
+}
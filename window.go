@@ -0,0 +1,40 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/c-bata/goptuna"
+)
+
+var _ goptuna.Storage = &windowedStorage{}
+
+// windowedStorage returns only the most recent finished trials from
+// GetAllTrials, so that the sampler observing it forgets older trials. The
+// other methods are passed through to the wrapped storage.
+type windowedStorage struct {
+	goptuna.Storage
+	window int
+}
+
+// GetAllTrials returns the window of the finished trials with the largest
+// numbers. The running and failed trials are left out because TPE doesn't
+// observe them.
+func (s *windowedStorage) GetAllTrials(studyID int) ([]goptuna.FrozenTrial, error) {
+	trials, err := s.Storage.GetAllTrials(studyID)
+	if err != nil {
+		return nil, err
+	}
+	finished := make([]goptuna.FrozenTrial, 0, len(trials))
+	for _, t := range trials {
+		if t.State == goptuna.TrialStateComplete || t.State == goptuna.TrialStatePruned {
+			finished = append(finished, t)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].Number < finished[j].Number
+	})
+	if len(finished) > s.window {
+		finished = finished[len(finished)-s.window:]
+	}
+	return finished, nil
+}
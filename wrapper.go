@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"strings"
+)
+
+// wrapCommand prepends the -wrapper command to the command line of a libffm
+// binary. The wrapper is split on whitespace, without shell quoting.
+func wrapCommand(cfg *Config, bin string, args []string) (string, []string) {
+	wrapper := strings.Fields(cfg.Wrapper)
+	if len(wrapper) == 0 {
+		return bin, args
+	}
+	wrapped := make([]string, 0, len(wrapper)+len(args))
+	wrapped = append(wrapped, wrapper[1:]...)
+	wrapped = append(wrapped, bin)
+	wrapped = append(wrapped, args...)
+	return wrapper[0], wrapped
+}
+
+// trainEnv returns the environment of ffm-train: the inherited one with the
+// variables of -env, or nil to inherit it as is.
+func trainEnv(cfg *Config) []string {
+	if len(cfg.TrainEnv) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(cfg.TrainEnv))
+	for k := range cfg.TrainEnv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	env := os.Environ()
+	for _, k := range keys {
+		env = append(env, k+"="+cfg.TrainEnv[k])
+	}
+	return env
+}